@@ -1,18 +1,33 @@
 package main
 
 import (
+	"context"
 	"log"
 	"modulyn/pkg/controllers"
 	"modulyn/pkg/db"
 	"modulyn/pkg/middlewares"
+	"modulyn/pkg/models"
+	"modulyn/pkg/ratelimit"
+	"modulyn/pkg/retention"
 	"modulyn/pkg/server"
+	"modulyn/pkg/telemetry"
+	"modulyn/pkg/webhooks"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	shutdownTracing, err := telemetry.InitTracing(context.Background(), os.Getenv("OTEL_TRACES_EXPORTER"))
+	if err != nil {
+		log.Fatalln("Failed to initialize tracing: ", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	store := server.NewStore()
 
 	conn, err := db.InitDB(os.Getenv("ENABLE_SQL_LOGGING") == "true")
@@ -21,29 +36,357 @@ func main() {
 	}
 	defer conn.Close()
 
-	controllers := controllers.New(conn, store)
+	limiter := ratelimit.New(conn, ratelimit.ConfigFromEnv())
+
+	dispatcher := webhooks.New(conn, webhooks.ConfigFromEnv())
+
+	purger := retention.New(conn, retention.ConfigFromEnv())
+
+	controllers := controllers.New(conn, store, limiter, dispatcher, purger)
 
 	mux := http.NewServeMux()
 
+	apiCors := middlewares.CorsMiddleware(middlewares.CorsConfigFromEnv("CORS_API", middlewares.CorsConfig{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match", "X-Correlation-ID", "Traceparent", "Tracestate"},
+		ExposedHeaders: []string{"Content-Type", "ETag", "Retry-After", "X-Correlation-ID", "Traceparent"},
+		MaxAge:         10 * time.Minute,
+	}))
+	sdkCors := middlewares.CorsMiddleware(middlewares.CorsConfigFromEnv("CORS_SDK", middlewares.CorsConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet},
+		AllowedHeaders: []string{"Content-Type", "X-Correlation-ID", "Traceparent", "Tracestate"},
+		ExposedHeaders: []string{"Content-Type", "Retry-After", "X-Correlation-ID"},
+		MaxAge:         10 * time.Minute,
+	}))
+
 	// events
-	mux.HandleFunc("/events", controllers.EventsController)
+	mux.Handle("/events", sdkCors(http.HandlerFunc(controllers.EventsController)))
+
+	mux.Handle("/client/events", sdkCors(http.HandlerFunc(controllers.ClientEventsController)))
+
+	authenticate := middlewares.AuthMiddleware(conn, os.Getenv("MODULYN_ADMIN_TOKEN"))
+	limitToken := middlewares.TokenRateLimitMiddleware(limiter)
+	limitBody := middlewares.BodyLimitMiddleware(middlewares.MaxBodyBytesFromEnv())
+	authorize := middlewares.AuthorizationMiddleware(conn)
+
+	// api registers a management route behind the api CORS policy,
+	// authentication, the token rate limit, the body size limit and the
+	// role policy
+	api := func(pattern string, policy middlewares.Policy, handler http.HandlerFunc) {
+		mux.Handle(pattern, apiCors(authenticate(limitToken(limitBody(authorize(policy, handler))))))
+	}
 
 	// features
-	mux.HandleFunc("/api/v1/projects/{projectId}/features", controllers.FeaturesController)
+	api("/api/v1/projects/{projectId}/features", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:  models.RoleViewer,
+			http.MethodPost: models.RoleEditor,
+		},
+	}, controllers.FeaturesController)
+
+	api("/api/v1/projects/{projectId}/features/{featureId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:    models.RoleViewer,
+			http.MethodPut:    models.RoleEditor,
+			http.MethodDelete: models.RoleEditor,
+		},
+		Environments: func(r *http.Request) ([]string, error) {
+			if r.Method != http.MethodPut {
+				return nil, nil
+			}
+			return middlewares.UpdateFeatureEnvironments(r)
+		},
+	}, controllers.FeatureByIdController)
+
+	// features/{featureId}/client-side, features/{featureId}/metadata,
+	// features/{featureId}/restore and features/by-key/{key}
+	api("/api/v1/projects/{projectId}/features/{featureId}/{action}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:  models.RoleViewer,
+			http.MethodPut:  models.RoleEditor,
+			http.MethodPost: models.RoleEditor,
+		},
+	}, controllers.FeatureActionsController)
 
-	mux.HandleFunc("/api/v1/projects/{projectId}/features/{featureId}", controllers.FeatureByIdController)
+	// alias of features/by-key/{key} outside of features/, so keys never
+	// compete with feature ids for a path segment
+	api("/api/v1/projects/{projectId}/feature-keys/{key}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.FeatureByKeyController)
+
+	// tags
+	api("/api/v1/projects/{projectId}/tags", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.TagsController)
+
+	api("/api/v1/projects/{projectId}/tags/{tag}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPut:    models.RoleEditor,
+			http.MethodDelete: models.RoleEditor,
+		},
+	}, controllers.TagByNameController)
 
 	// projects
-	mux.HandleFunc("/api/v1/projects", controllers.ProjectsController)
+	api("/api/v1/projects", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:  models.RoleViewer,
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.ProjectsController)
+
+	api("/api/v1/projects/{projectId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:    models.RoleViewer,
+			http.MethodPut:    models.RoleAdmin,
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.ProjectByIdControllers)
+
+	api("/api/v1/projects/{projectId}/restore", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.RestoreProjectController)
+
+	// export and import
+	api("/api/v1/projects/{projectId}/export", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.ExportProjectController)
+
+	api("/api/v1/projects/import", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.ImportProjectsController)
+
+	api("/api/v1/projects/{projectId}/import", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.ImportProjectController)
 
-	mux.HandleFunc("/api/v1/projects/{projectId}", controllers.ProjectByIdControllers)
+	// trash
+	api("/api/v1/projects/trash", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleAdmin,
+		},
+	}, controllers.DeletedProjectsController)
+
+	api("/api/v1/projects/{projectId}/trash", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.ProjectTrashController)
 
 	// environments
-	mux.HandleFunc("/api/v1/projects/{projectId}/environments", controllers.EnvironmentsController)
+	api("/api/v1/projects/{projectId}/environments", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:  models.RoleViewer,
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.EnvironmentsController)
+
+	api("/api/v1/projects/{projectId}/environments/compare", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.CompareEnvironmentsController)
+
+	api("/api/v1/projects/{projectId}/environments/{environmentId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:    models.RoleViewer,
+			http.MethodPut:    models.RoleAdmin,
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.EnvironmentByIdControllers)
+
+	api("/api/v1/projects/{projectId}/environments/{environmentId}/clone", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.CloneEnvironmentController)
+
+	api("/api/v1/projects/{projectId}/environments/{environmentId}/restore", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.RestoreEnvironmentController)
+
+	// sdk keys
+	api("/api/v1/projects/{projectId}/environments/{environmentId}/sdk-keys", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleEditor,
+		},
+	}, controllers.SDKKeysController)
 
-	mux.HandleFunc("/api/v1/projects/{projectId}/environments/{environmentId}", controllers.EnvironmentByIdControllers)
+	api("/api/v1/projects/{projectId}/environments/{environmentId}/sdk-keys/rotate", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.RotateSDKKeyController)
 
-	handler := middlewares.CorrelationMiddleware(mux)
+	api("/api/v1/projects/{projectId}/environments/{environmentId}/sdk-keys/{keyId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.SDKKeyByIdController)
 
-	http.ListenAndServe(":8080", handler)
+	// change requests
+	api("/api/v1/projects/{projectId}/change-requests", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.ChangeRequestsController)
+
+	api("/api/v1/projects/{projectId}/change-requests/{changeRequestId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleViewer,
+		},
+	}, controllers.ChangeRequestByIdController)
+
+	api("/api/v1/projects/{projectId}/change-requests/{changeRequestId}/approve", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleEditor,
+		},
+		Environments: middlewares.ChangeRequestEnvironments(conn),
+	}, controllers.ApproveChangeRequestController)
+
+	api("/api/v1/projects/{projectId}/change-requests/{changeRequestId}/reject", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleEditor,
+		},
+		Environments: middlewares.ChangeRequestEnvironments(conn),
+	}, controllers.RejectChangeRequestController)
+
+	// flags as code
+	api("/api/v1/projects/{projectId}/spec/plan", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleViewer,
+		},
+	}, controllers.SpecPlanController)
+
+	api("/api/v1/projects/{projectId}/spec/apply", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.SpecApplyController)
+
+	// promotions
+	api("/api/v1/projects/{projectId}/promotions/preview", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleViewer,
+		},
+	}, controllers.PromotionPreviewController)
+
+	api("/api/v1/projects/{projectId}/promotions", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleEditor,
+		},
+		Environments: middlewares.PromotionEnvironments,
+	}, controllers.PromotionsController)
+
+	// webhooks
+	api("/api/v1/projects/{projectId}/webhooks", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:  models.RoleAdmin,
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.WebhooksController)
+
+	api("/api/v1/projects/{projectId}/webhooks/{webhookId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:    models.RoleAdmin,
+			http.MethodPut:    models.RoleAdmin,
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.WebhookByIdController)
+
+	api("/api/v1/projects/{projectId}/webhooks/{webhookId}/deliveries", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleAdmin,
+		},
+	}, controllers.WebhookDeliveriesController)
+
+	api("/api/v1/projects/{projectId}/webhooks/{webhookId}/test", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.TestWebhookController)
+
+	// limits
+	api("/api/v1/projects/{projectId}/limits", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:    models.RoleViewer,
+			http.MethodPut:    models.RoleAdmin,
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.ProjectLimitsController)
+
+	// roles
+	api("/api/v1/projects/{projectId}/roles", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet: models.RoleAdmin,
+		},
+	}, controllers.RolesController)
+
+	api("/api/v1/projects/{projectId}/roles/{tokenId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPut:    models.RoleAdmin,
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.RoleByTokenIdController)
+
+	// tokens
+	api("/api/v1/tokens", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:  models.RoleAdmin,
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.TokensController)
+
+	api("/api/v1/tokens/{tokenId}", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodGet:    models.RoleAdmin,
+			http.MethodDelete: models.RoleAdmin,
+		},
+	}, controllers.TokenByIdController)
+
+	// admin
+	api("/api/v1/admin/purge", middlewares.Policy{
+		Roles: map[string]string{
+			http.MethodPost: models.RoleAdmin,
+		},
+	}, controllers.PurgeController)
+
+	handler := middlewares.CorrelationMiddleware(middlewares.TracingMiddleware(middlewares.IPRateLimitMiddleware(limiter)(mux)))
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: handler,
+	}
+
+	// shut down gracefully so buffered spans are flushed on exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go dispatcher.Run(ctx)
+	go purger.Run(ctx)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println("Server stopped: ", err)
+	}
 }
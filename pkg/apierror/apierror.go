@@ -0,0 +1,102 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+)
+
+const (
+	CodeBadRequest           = "bad_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeRequestTooLarge      = "request_too_large"
+	CodePreconditionRequired = "precondition_required"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal_error"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: CodeRequestTooLarge,
+	http.StatusPreconditionRequired:  CodePreconditionRequired,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+}
+
+// Error writes the error envelope with the code matching the status, it is
+// the JSON counterpart of http.Error
+func Error(w http.ResponseWriter, r *http.Request, message string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	Write(w, r, status, models.APIError{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// Write writes an error envelope carrying the correlation ID of the request
+func Write(w http.ResponseWriter, r *http.Request, status int, apiError models.APIError) {
+	if correlationID, ok := r.Context().Value(db.CorrelationKey).(string); ok {
+		apiError.CorrelationID = correlationID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error: apiError,
+	})
+}
+
+// FromError maps the typed errors of pkg/db to their status and code, any
+// other error is reported as a 500 with the fallback message
+func FromError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	var validationError *db.ValidationError
+	var preconditionError *db.PreconditionFailedError
+
+	switch {
+	case errors.As(err, &validationError):
+		Write(w, r, http.StatusBadRequest, models.APIError{
+			Code:    CodeValidationFailed,
+			Message: "The request is invalid",
+			Details: validationError.Fields,
+		})
+	case errors.As(err, &preconditionError):
+		Write(w, r, http.StatusPreconditionFailed, models.APIError{
+			Code:    CodePreconditionFailed,
+			Message: preconditionError.Message,
+			Current: preconditionError.Current,
+		})
+	case errors.Is(err, db.ErrNoRows):
+		Error(w, r, capitalize(err.Error()), http.StatusNotFound)
+	case errors.Is(err, db.ErrConflict), errors.Is(err, db.ErrNotPending):
+		Error(w, r, capitalize(err.Error()), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidCursor):
+		Error(w, r, "Invalid cursor", http.StatusBadRequest)
+	default:
+		Error(w, r, fallback, http.StatusInternalServerError)
+	}
+}
+
+func capitalize(message string) string {
+	if message == "" || message[0] < 'a' || message[0] > 'z' {
+		return message
+	}
+	return string(message[0]-'a'+'A') + message[1:]
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+	"slices"
+)
+
+var changeRequestStatuses = []string{
+	models.ChangeRequestPending,
+	models.ChangeRequestApplied,
+	models.ChangeRequestRejected,
+	models.ChangeRequestConflicted,
+}
+
+func (c *controller) ChangeRequestsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		status := r.URL.Query().Get("status")
+
+		if status != "" && !slices.Contains(changeRequestStatuses, status) {
+			apierror.Error(w, r, "status must be one of pending, applied, rejected or conflicted", http.StatusBadRequest)
+			return
+		}
+
+		changeRequests, err := c.conn.GetChangeRequests(r.Context(), projectID, status)
+		if err != nil {
+			log.Println("Error getting change requests:", err)
+			apierror.FromError(w, r, err, "Failed to get change requests")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: changeRequests,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) ChangeRequestByIdController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		changeRequestID := r.PathValue("changeRequestId")
+
+		changeRequest, err := c.conn.GetChangeRequest(r.Context(), projectID, changeRequestID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Change request not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error getting change request:", err)
+			apierror.FromError(w, r, err, "Failed to get change request")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: changeRequest,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) ApproveChangeRequestController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		changeRequestID := r.PathValue("changeRequestId")
+
+		// the admin token is shared, an approval made with it could come
+		// from the author
+		principal, _ := db.PrincipalFromContext(r.Context())
+		if principal.TokenID == models.BootstrapTokenID {
+			apierror.Error(w, r, "Change requests cannot be approved with the admin token, use an API token", http.StatusForbidden)
+			return
+		}
+
+		reviewChangeRequest, ok := c.decodeReview(w, r, projectID, changeRequestID)
+		if !ok {
+			return
+		}
+
+		changeRequest, err := c.conn.ApplyChangeRequest(r.Context(), projectID, changeRequestID, principal, reviewChangeRequest.Comment)
+		if errors.Is(err, db.ErrNotPending) {
+			apierror.Error(w, r, "Change request is already "+changeRequest.Status, http.StatusConflict)
+			return
+		}
+		if errors.Is(err, db.ErrConflict) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(models.Response{
+				Data: changeRequest,
+			})
+			return
+		}
+		if err != nil {
+			log.Println("Error applying change request:", err)
+			apierror.FromError(w, r, err, "Failed to approve change request")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: changeRequest,
+		})
+
+		updatedFeatures, err := c.conn.GetFeaturesByID(r.Context(), projectID, changeRequest.FeatureID)
+		if err != nil {
+			log.Println("Error getting updated feature:", err)
+			return
+		}
+
+		i := slices.IndexFunc(updatedFeatures, func(f *models.Feature) bool {
+			return f.EnvironmentID == changeRequest.EnvironmentID
+		})
+		if i < 0 {
+			return
+		}
+
+		bytes, _ := json.Marshal(updatedFeatures[i])
+		event := models.Event{
+			Type: "feature_updated",
+			Data: bytes,
+		}
+
+		c.notify(r.Context(), projectID, changeRequest.EnvironmentID, event)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) RejectChangeRequestController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		changeRequestID := r.PathValue("changeRequestId")
+
+		reviewChangeRequest, ok := c.decodeReview(w, r, projectID, changeRequestID)
+		if !ok {
+			return
+		}
+
+		principal, _ := db.PrincipalFromContext(r.Context())
+		changeRequest, err := c.conn.RejectChangeRequest(r.Context(), projectID, changeRequestID, principal, reviewChangeRequest.Comment)
+		if errors.Is(err, db.ErrNotPending) {
+			apierror.Error(w, r, "Change request is already "+changeRequest.Status, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("Error rejecting change request:", err)
+			apierror.FromError(w, r, err, "Failed to reject change request")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: changeRequest,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeReview reads the optional review body and makes sure the reviewer
+// is not the author of the change request
+func (c *controller) decodeReview(w http.ResponseWriter, r *http.Request, projectID, changeRequestID string) (*models.ReviewChangeRequest, bool) {
+	var reviewChangeRequest models.ReviewChangeRequest
+	if !decodeOptionalBody(w, r, &reviewChangeRequest) {
+		return nil, false
+	}
+
+	changeRequest, err := c.conn.GetChangeRequest(r.Context(), projectID, changeRequestID)
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Error(w, r, "Change request not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Error getting change request:", err)
+		apierror.FromError(w, r, err, "Failed to review change request")
+		return nil, false
+	}
+
+	principal, _ := db.PrincipalFromContext(r.Context())
+	if principal.TokenID == changeRequest.AuthorTokenID {
+		apierror.Error(w, r, "Change requests must be reviewed by someone other than the author", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &reviewChangeRequest, true
+}
@@ -1,35 +1,85 @@
 package controllers
 
 import (
+	"context"
 	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"modulyn/pkg/ratelimit"
+	"modulyn/pkg/retention"
 	"modulyn/pkg/server"
+	"modulyn/pkg/webhooks"
 	"net/http"
 )
 
 type Controller interface {
 	EventsController(w http.ResponseWriter, r *http.Request)
+	ClientEventsController(w http.ResponseWriter, r *http.Request)
 	FeaturesController(w http.ResponseWriter, r *http.Request)
 	FeatureByIdController(w http.ResponseWriter, r *http.Request)
+	FeatureClientSideController(w http.ResponseWriter, r *http.Request)
+	FeatureMetadataController(w http.ResponseWriter, r *http.Request)
+	FeatureByKeyController(w http.ResponseWriter, r *http.Request)
+	FeatureActionsController(w http.ResponseWriter, r *http.Request)
 	ProjectsController(w http.ResponseWriter, r *http.Request)
 	ProjectByIdControllers(w http.ResponseWriter, r *http.Request)
 	EnvironmentsController(w http.ResponseWriter, r *http.Request)
 	EnvironmentByIdControllers(w http.ResponseWriter, r *http.Request)
+	CloneEnvironmentController(w http.ResponseWriter, r *http.Request)
+	CompareEnvironmentsController(w http.ResponseWriter, r *http.Request)
+	TokensController(w http.ResponseWriter, r *http.Request)
+	TokenByIdController(w http.ResponseWriter, r *http.Request)
+	RolesController(w http.ResponseWriter, r *http.Request)
+	RoleByTokenIdController(w http.ResponseWriter, r *http.Request)
+	SDKKeysController(w http.ResponseWriter, r *http.Request)
+	RotateSDKKeyController(w http.ResponseWriter, r *http.Request)
+	SDKKeyByIdController(w http.ResponseWriter, r *http.Request)
+	ProjectLimitsController(w http.ResponseWriter, r *http.Request)
+	ChangeRequestsController(w http.ResponseWriter, r *http.Request)
+	ChangeRequestByIdController(w http.ResponseWriter, r *http.Request)
+	ApproveChangeRequestController(w http.ResponseWriter, r *http.Request)
+	RejectChangeRequestController(w http.ResponseWriter, r *http.Request)
+	WebhooksController(w http.ResponseWriter, r *http.Request)
+	WebhookByIdController(w http.ResponseWriter, r *http.Request)
+	WebhookDeliveriesController(w http.ResponseWriter, r *http.Request)
+	TestWebhookController(w http.ResponseWriter, r *http.Request)
+	ProjectTrashController(w http.ResponseWriter, r *http.Request)
+	DeletedProjectsController(w http.ResponseWriter, r *http.Request)
+	RestoreProjectController(w http.ResponseWriter, r *http.Request)
+	RestoreEnvironmentController(w http.ResponseWriter, r *http.Request)
+	RestoreFeatureController(w http.ResponseWriter, r *http.Request)
+	PurgeController(w http.ResponseWriter, r *http.Request)
+	ExportProjectController(w http.ResponseWriter, r *http.Request)
+	ImportProjectsController(w http.ResponseWriter, r *http.Request)
+	ImportProjectController(w http.ResponseWriter, r *http.Request)
+	SpecPlanController(w http.ResponseWriter, r *http.Request)
+	SpecApplyController(w http.ResponseWriter, r *http.Request)
+	PromotionPreviewController(w http.ResponseWriter, r *http.Request)
+	PromotionsController(w http.ResponseWriter, r *http.Request)
+	TagsController(w http.ResponseWriter, r *http.Request)
+	TagByNameController(w http.ResponseWriter, r *http.Request)
 }
 
 type controller struct {
-	conn  db.Conn
-	store server.Store
+	conn     db.Conn
+	store    server.Store
+	limiter  *ratelimit.Limiter
+	webhooks *webhooks.Dispatcher
+	purger   *retention.Purger
 }
 
-func New(conn db.Conn, store server.Store) Controller {
+func New(conn db.Conn, store server.Store, limiter *ratelimit.Limiter, webhooks *webhooks.Dispatcher, purger *retention.Purger) Controller {
 	return &controller{
-		conn:  conn,
-		store: store,
+		conn:     conn,
+		store:    store,
+		limiter:  limiter,
+		webhooks: webhooks,
+		purger:   purger,
 	}
 }
 
-func enableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-	(*w).Header().Set("Access-Control-Allow-Headers", "*")
-	(*w).Header().Set("Access-Control-Allow-Methods", "*")
+// notify broadcasts an event to the SDKs connected to an environment and
+// publishes it to the project's webhooks
+func (c *controller) notify(ctx context.Context, projectID, environmentID string, event models.Event) {
+	c.store.NotifyClients(ctx, event, environmentID)
+	c.webhooks.Publish(ctx, projectID, environmentID, event)
 }
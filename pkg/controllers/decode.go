@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/models"
+	"net/http"
+)
+
+// decodeBody strictly decodes a JSON request body into v. Unknown fields,
+// trailing data and bodies over the size limit are rejected, the error
+// response is written and false returned.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decode(w, r, v, false)
+}
+
+// decodeOptionalBody is decodeBody for endpoints whose body may be empty
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decode(w, r, v, true)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any, optional bool) bool {
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errors.New("unexpected data after the JSON body")
+		}
+	}
+	if err == nil || (optional && errors.Is(err, io.EOF)) {
+		return true
+	}
+
+	writeDecodeError(w, r, err)
+	return false
+}
+
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		apierror.Error(w, r, fmt.Sprintf("Request body must not exceed %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, io.EOF):
+		apierror.Error(w, r, "Request body is required", http.StatusBadRequest)
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		apierror.Error(w, r, "Request body is not valid JSON", http.StatusBadRequest)
+	case errors.As(err, &typeError):
+		apierror.Write(w, r, http.StatusBadRequest, models.APIError{
+			Code:    apierror.CodeValidationFailed,
+			Message: "The request is invalid",
+			Details: []models.FieldError{{Field: typeError.Field, Message: "must be of type " + typeError.Type.String()}},
+		})
+	default:
+		// the decoder reports unknown fields as `json: unknown field "name"`
+		var field string
+		if _, scanErr := fmt.Sscanf(err.Error(), "json: unknown field %q", &field); scanErr == nil {
+			apierror.Write(w, r, http.StatusBadRequest, models.APIError{
+				Code:    apierror.CodeValidationFailed,
+				Message: "The request is invalid",
+				Details: []models.FieldError{{Field: field, Message: "is not a known field"}},
+			})
+			return
+		}
+		apierror.Error(w, r, "Invalid request body", http.StatusBadRequest)
+	}
+}
@@ -1,27 +1,25 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"modulyn/pkg/apierror"
 	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
 	"net/http"
 )
 
 func (c *controller) EnvironmentsController(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
 
 	switch r.Method {
-	case http.MethodOptions:
-		w.WriteHeader(http.StatusNoContent)
 	case http.MethodGet:
 		projectID := r.PathValue("projectId")
 
 		environments, err := c.conn.GetEnvironments(r.Context(), projectID)
 		if err != nil {
-			http.Error(w, "Failed to get environments", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to get environments")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -31,45 +29,126 @@ func (c *controller) EnvironmentsController(w http.ResponseWriter, r *http.Reque
 	case http.MethodPost:
 		projectID := r.PathValue("projectId")
 		var createEnvironmentRequest models.CreateEnvironmentRequest
-		if err := json.NewDecoder(r.Body).Decode(&createEnvironmentRequest); err != nil {
-			log.Println("Error decoding request body:", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeBody(w, r, &createEnvironmentRequest) {
 			return
 		}
-		defer r.Body.Close()
 
-		environmentID, err := c.conn.CreateEnvironment(r.Context(), projectID, &createEnvironmentRequest)
+		c.createEnvironment(w, r, projectID, &createEnvironmentRequest)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CloneEnvironmentController creates an environment with the feature
+// states, values and targeting of the environment in the path
+func (c *controller) CloneEnvironmentController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		environmentID := r.PathValue("environmentId")
+
+		var cloneEnvironmentRequest models.CloneEnvironmentRequest
+		if !decodeBody(w, r, &cloneEnvironmentRequest) {
+			return
+		}
+
+		if _, err := c.conn.GetEnvironment(r.Context(), projectID, environmentID); err != nil {
+			apierror.FromError(w, r, err, "Failed to clone environment")
+			return
+		}
+
+		c.createEnvironment(w, r, projectID, &models.CreateEnvironmentRequest{
+			Name:                cloneEnvironmentRequest.Name,
+			SourceEnvironmentID: environmentID,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CompareEnvironmentsController shows the drift between the base and target
+// environments given as query parameters
+func (c *controller) CompareEnvironmentsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		baseEnvironmentID := r.URL.Query().Get("base")
+		targetEnvironmentID := r.URL.Query().Get("target")
+
+		environments, err := c.conn.GetEnvironments(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting environments:", err)
+			apierror.FromError(w, r, err, "Failed to compare environments")
+			return
+		}
+		if err := validation.CompareEnvironments(baseEnvironmentID, targetEnvironmentID, environments); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
+			return
+		}
+
+		comparison, err := c.conn.CompareEnvironments(r.Context(), projectID, baseEnvironmentID, targetEnvironmentID)
 		if err != nil {
-			log.Println("Error creating environment:", err)
-			http.Error(w, "Failed to create environment", http.StatusInternalServerError)
+			log.Println("Error comparing environments:", err)
+			apierror.FromError(w, r, err, "Failed to compare environments")
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(models.Response{
-			Data: environmentID,
+			Data: comparison,
 		})
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+func (c *controller) createEnvironment(w http.ResponseWriter, r *http.Request, projectID string, createEnvironmentRequest *models.CreateEnvironmentRequest) {
+	if _, err := c.conn.GetProject(r.Context(), projectID); err != nil {
+		apierror.FromError(w, r, err, "Failed to create environment")
+		return
+	}
+
+	environments, err := c.conn.GetEnvironments(r.Context(), projectID)
+	if err != nil {
+		log.Println("Error getting environments:", err)
+		apierror.FromError(w, r, err, "Failed to create environment")
+		return
+	}
+	if err := validation.CreateEnvironment(createEnvironmentRequest, environments); err != nil {
+		apierror.FromError(w, r, err, "Invalid request")
+		return
+	}
+
+	environmentID, err := c.conn.CreateEnvironment(r.Context(), projectID, createEnvironmentRequest)
+	if err != nil {
+		log.Println("Error creating environment:", err)
+		apierror.FromError(w, r, err, "Failed to create environment")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.Response{
+		Data: environmentID,
+	})
+
+	c.publishEnvironment(r.Context(), projectID, environmentID, models.WebhookEventEnvironmentCreated, nil)
+}
+
 func (c *controller) EnvironmentByIdControllers(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
 
 	switch r.Method {
-	case http.MethodOptions:
-		w.WriteHeader(http.StatusNoContent)
 	case http.MethodGet:
 		projectID := r.PathValue("projectId")
 		environmentID := r.PathValue("environmentId")
 		environment, err := c.conn.GetEnvironment(r.Context(), projectID, environmentID)
 		if err != nil {
 			log.Println("Error fetching environment:", err)
-			http.Error(w, "Failed to get environment", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to get environment")
 			return
 		}
 
@@ -81,31 +160,60 @@ func (c *controller) EnvironmentByIdControllers(w http.ResponseWriter, r *http.R
 		projectID := r.PathValue("projectId")
 		environmentID := r.PathValue("environmentId")
 		var updateEnvironmentRequest models.UpdateEnvironmentRequest
-		if err := json.NewDecoder(r.Body).Decode(&updateEnvironmentRequest); err != nil {
-			log.Println("Error decoding request body:", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeBody(w, r, &updateEnvironmentRequest) {
+			return
+		}
+
+		if err := validation.UpdateEnvironment(&updateEnvironmentRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
 			return
 		}
-		defer r.Body.Close()
 
 		if err := c.conn.UpdateEnvironment(r.Context(), projectID, environmentID, &updateEnvironmentRequest); err != nil {
 			log.Println("Error updating environment:", err)
-			http.Error(w, "Failed to update environment", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to update environment")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
+
+		c.publishEnvironment(r.Context(), projectID, environmentID, models.WebhookEventEnvironmentUpdated, nil)
 	case http.MethodDelete:
-		// Handle DELETE request
 		projectID := r.PathValue("projectId")
 		environmentID := r.PathValue("environmentId")
 
+		existingEnvironment, _ := c.conn.GetEnvironment(r.Context(), projectID, environmentID)
+
 		if err := c.conn.DeleteEnvironment(r.Context(), projectID, environmentID); err != nil {
 			log.Println("Error deleting environment:", err)
+			apierror.FromError(w, r, err, "Failed to delete environment")
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
+
+		c.publishEnvironment(r.Context(), projectID, environmentID, models.WebhookEventEnvironmentDeleted, existingEnvironment)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// publishEnvironment sends an environment change to the project's webhooks,
+// SDKs are not told about environment changes. The environment is loaded
+// when it is not passed in.
+func (c *controller) publishEnvironment(ctx context.Context, projectID, environmentID, eventType string, environment *models.Environment) {
+	if environment == nil {
+		var err error
+		environment, err = c.conn.GetEnvironment(ctx, projectID, environmentID)
+		if err != nil {
+			log.Println("Error getting environment:", err)
+			return
+		}
+	}
+
+	bytes, _ := json.Marshal(environment)
+	c.webhooks.Publish(ctx, projectID, environmentID, models.Event{
+		Type: eventType,
+		Data: bytes,
+	})
+}
@@ -1,42 +1,107 @@
 package controllers
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
 	"modulyn/pkg/models"
+	"modulyn/pkg/ratelimit"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// connectionRetryAfter is suggested to clients rejected by a connection quota
+const connectionRetryAfter = 30 * time.Second
+
 func (c *controller) EventsController(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	sdkKey := r.URL.Query().Get("sdk_key")
+	if sdkKey == "" {
+		apierror.Error(w, r, "Missing sdk_key parameter", http.StatusBadRequest)
+		return
+	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	c.streamEvents(w, r, sdkKey, models.SDKKeyKindServer, nil)
+}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
+// ClientEventsController streams evaluated values of the features marked as
+// available to client-side SDKs. The user context is a base64url encoded
+// JSON object passed in the context query parameter.
+func (c *controller) ClientEventsController(w http.ResponseWriter, r *http.Request) {
+	clientKey := r.URL.Query().Get("client_key")
+	if clientKey == "" {
+		apierror.Error(w, r, "Missing client_key parameter", http.StatusBadRequest)
+		return
+	}
 
-	sdkKey := r.URL.Query().Get("sdk_key")
-	if sdkKey == "" {
-		http.Error(w, "Missing sdk_key parameter", http.StatusBadRequest)
+	userContext, err := decodeUserContext(r.URL.Query().Get("context"))
+	if err != nil {
+		log.Println("Error decoding user context:", err)
+		apierror.Error(w, r, "Invalid context parameter", http.StatusBadRequest)
 		return
 	}
 
+	c.streamEvents(w, r, clientKey, models.SDKKeyKindClient, userContext)
+}
+
+func (c *controller) streamEvents(w http.ResponseWriter, r *http.Request, sdkKey, kind string, userContext map[string]string) {
 	appId := r.URL.Query().Get("appid")
 	if appId == "" {
-		http.Error(w, "Missing appid parameter", http.StatusBadRequest)
+		apierror.Error(w, r, "Missing appid parameter", http.StatusBadRequest)
+		return
+	}
+	tagMatch, err := parseTagMatch(r.URL.Query())
+	if err != nil {
+		apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := c.conn.ResolveSDKKey(r.Context(), sdkKey)
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Error(w, r, "Invalid sdk key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println("Error resolving sdk key:", err)
+		apierror.FromError(w, r, err, "Failed to resolve sdk key")
+		return
+	}
+	if key.Kind != kind {
+		apierror.Error(w, r, fmt.Sprintf("A %s sdk key cannot be used on this endpoint", key.Kind), http.StatusForbidden)
 		return
 	}
 
-	client := models.Client{
-		SDKKey:   sdkKey,
-		AppID:    appId,
-		Messages: make(chan models.Event),
+	if ok, retryAfter := c.limiter.AllowSDKKey(r.Context(), key.ProjectID, key.ID); !ok {
+		ratelimit.Reject(w, r, retryAfter, "Too many connection attempts for this sdk key")
+		return
+	}
+
+	client := &models.Client{
+		SDKKey:        sdkKey,
+		SDKKeyID:      key.ID,
+		EnvironmentID: key.EnvironmentID,
+		AppID:         appId,
+		Messages:      make(chan models.Event),
+		Done:          make(chan struct{}),
+		ClientSide:    kind == models.SDKKeyKindClient,
+		UserContext:   userContext,
+		Tags:          r.URL.Query()["tag"],
+		TagMatch:      tagMatch,
+	}
+	if err := c.store.Subscribe(client, c.limiter.ConnectionQuota(r.Context(), key.ProjectID)); err != nil {
+		ratelimit.Reject(w, r, connectionRetryAfter, err.Error())
+		return
 	}
-	c.store.Subscribe(client)
 	defer c.store.Unsubscribe(client)
 
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
 	go func() {
 		for event := range client.Messages {
 			data, _ := json.Marshal(event)
@@ -46,21 +111,38 @@ func (c *controller) EventsController(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// send all features to the client when they connect
-	features, err := c.conn.GetFeaturesByEnvironmentID(r.Context(), sdkKey)
+	features, err := c.conn.GetFeaturesByEnvironmentID(r.Context(), client.EnvironmentID)
 	if err != nil {
-		http.Error(w, "Failed to get features", http.StatusInternalServerError)
+		apierror.FromError(w, r, err, "Failed to get features")
 		return
 	}
 
 	// send the features as an initial event
-	featuresData, _ := json.Marshal(features)
-	initialEvent := models.Event{
-		Type: "all_features",
-		Data: featuresData,
+	client.Messages <- c.store.Snapshot(client, features)
+
+	select {
+	case <-r.Context().Done():
+	case <-client.Done:
 	}
+}
 
-	client.Messages <- initialEvent
+func decodeUserContext(encoded string) (map[string]string, error) {
+	userContext := make(map[string]string)
+	if encoded == "" {
+		return userContext, nil
+	}
 
-	closeNotify := r.Context().Done()
-	<-closeNotify
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes map[string]any
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, err
+	}
+	for name, value := range attributes {
+		userContext[name] = fmt.Sprint(value)
+	}
+	return userContext, nil
 }
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
+	"net/http"
+	"net/url"
+)
+
+// ExportProjectController downloads the project as an export document. The
+// document is not wrapped in a response so it can be imported as is.
+func (c *controller) ExportProjectController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		document, err := c.conn.ExportProject(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error exporting project:", err)
+			apierror.FromError(w, r, err, "Failed to export project")
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "project-"+projectID+".json"))
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(document)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ImportProjectsController creates a new project from an export document
+func (c *controller) ImportProjectsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		if principal, _ := db.PrincipalFromContext(r.Context()); principal.Scope != models.TokenScopeOrganization {
+			apierror.Error(w, r, "Creating projects requires an organization token", http.StatusForbidden)
+			return
+		}
+
+		c.importProject(w, r, "")
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ImportProjectController merges an export document into the project
+func (c *controller) ImportProjectController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		c.importProject(w, r, r.PathValue("projectId"))
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// importProject imports the document in the body with the strategy and
+// name query parameters, into a new project when projectID is empty
+func (c *controller) importProject(w http.ResponseWriter, r *http.Request, projectID string) {
+	var document models.ProjectExport
+	if !decodeBody(w, r, &document) {
+		return
+	}
+
+	options := importOptions(r.URL.Query())
+	if err := validation.ImportProject(&document, options); err != nil {
+		apierror.FromError(w, r, err, "Invalid request")
+		return
+	}
+
+	principal, _ := db.PrincipalFromContext(r.Context())
+	report, err := c.conn.ImportProject(r.Context(), projectID, &document, options, principal)
+	if err != nil {
+		log.Println("Error importing project:", err)
+		apierror.FromError(w, r, err, "Failed to import project")
+		return
+	}
+
+	if projectID == "" {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(models.Response{
+		Data: report,
+	})
+
+	c.announceImport(r.Context(), report)
+}
+
+func importOptions(query url.Values) *models.ImportOptions {
+	options := &models.ImportOptions{
+		Strategy: query.Get("strategy"),
+		Name:     query.Get("name"),
+	}
+	if options.Strategy == "" {
+		options.Strategy = models.ImportStrategySkip
+	}
+	return options
+}
+
+// announceImport sends the environments and features an import created or
+// changed to SDKs and webhooks
+func (c *controller) announceImport(ctx context.Context, report *models.ImportReport) {
+	for _, environment := range report.Environments {
+		if environment.Action == models.ImportActionCreated {
+			c.publishEnvironment(ctx, report.ProjectID, environment.ID, models.WebhookEventEnvironmentCreated, nil)
+		}
+	}
+
+	actions := make(map[string]string, len(report.Features))
+	for _, feature := range report.Features {
+		actions[feature.ID] = feature.Action
+	}
+
+	features, _, err := c.conn.GetFeatures(ctx, report.ProjectID, &models.FeatureFilter{})
+	if err != nil {
+		log.Println("Error getting imported features:", err)
+		return
+	}
+
+	for _, feature := range features {
+		var eventType string
+		switch actions[feature.ID] {
+		case models.ImportActionCreated:
+			eventType = models.WebhookEventFeatureCreated
+		case models.ImportActionUpdated:
+			eventType = models.WebhookEventFeatureUpdated
+		default:
+			continue
+		}
+
+		bytes, _ := json.Marshal(feature)
+		event := models.Event{
+			Type: eventType,
+			Data: bytes,
+		}
+
+		c.notify(ctx, report.ProjectID, feature.EnvironmentID, event)
+	}
+}
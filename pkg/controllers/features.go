@@ -2,52 +2,116 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
 	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 func (c *controller) FeaturesController(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
 	switch r.Method {
-	case http.MethodOptions:
-		w.WriteHeader(http.StatusNoContent)
 	case http.MethodGet:
 		projectID := r.PathValue("projectId")
 		queryParams := r.URL.Query()
 
-		searchTerm := queryParams.Get("search")
+		listOptions, err := parseListOptions(queryParams)
+		if err != nil {
+			apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tagMatch, err := parseTagMatch(queryParams)
+		if err != nil {
+			apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		features, err := c.conn.GetFeatures(r.Context(), projectID, searchTerm)
+		filter := models.FeatureFilter{
+			ListOptions:   listOptions,
+			Search:        queryParams.Get("search"),
+			EnvironmentID: queryParams.Get("environmentId"),
+			UpdatedSince:  queryParams.Get("updatedSince"),
+			Tags:          slices.Compact(slices.Sorted(slices.Values(queryParams["tag"]))),
+			TagMatch:      tagMatch,
+			Owner:         queryParams.Get("owner"),
+			Maintainer:    queryParams.Get("maintainer"),
+			Type:          queryParams.Get("type"),
+		}
+		// property=key:value, repeated to require several values
+		for _, value := range queryParams["property"] {
+			key, propertyValue, ok := strings.Cut(value, ":")
+			if !ok || key == "" {
+				apierror.Error(w, r, "property must be formatted as key:value", http.StatusBadRequest)
+				return
+			}
+			if filter.Properties == nil {
+				filter.Properties = make(map[string]string)
+			}
+			filter.Properties[key] = propertyValue
+		}
+		if value := queryParams.Get("enabled"); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				apierror.Error(w, r, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			filter.Enabled = &enabled
+		}
+		if filter.Type != "" && !slices.Contains(models.FeatureTypes, filter.Type) {
+			apierror.Error(w, r, "type must be one of "+strings.Join(models.FeatureTypes, ", "), http.StatusBadRequest)
+			return
+		}
+		if filter.UpdatedSince != "" {
+			if _, err := time.Parse(time.RFC3339, filter.UpdatedSince); err != nil {
+				apierror.Error(w, r, "updatedSince must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		features, next, err := c.conn.GetFeatures(r.Context(), projectID, &filter)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			apierror.Error(w, r, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			http.Error(w, "Failed to get features", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to get features")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(models.Response{
 			Data: features,
+			Next: next,
 		})
 	case http.MethodPost:
 		projectID := r.PathValue("projectId")
 		var createFeatureRequest models.CreateFeatureRequest
-		if err := json.NewDecoder(r.Body).Decode(&createFeatureRequest); err != nil {
-			log.Println("Error decoding request body:", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeBody(w, r, &createFeatureRequest) {
+			return
+		}
+		if err := validation.CreateFeature(&createFeatureRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
+			return
+		}
+
+		if _, err := c.conn.GetProject(r.Context(), projectID); err != nil {
+			apierror.FromError(w, r, err, "Failed to create feature")
 			return
 		}
-		defer r.Body.Close()
 
 		environments, err := c.conn.GetEnvironments(r.Context(), projectID)
 		if err != nil {
 			log.Println("Error getting environments:", err)
-			http.Error(w, "Failed to create feature", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to create feature")
 			return
 		}
 
@@ -55,7 +119,7 @@ func (c *controller) FeaturesController(w http.ResponseWriter, r *http.Request)
 
 		if err := c.conn.CreateFeature(r.Context(), featureID.String(), projectID, environments, &createFeatureRequest); err != nil {
 			log.Println("Error creating feature:", err)
-			http.Error(w, "Failed to create feature", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to create feature")
 			return
 		}
 
@@ -72,7 +136,7 @@ func (c *controller) FeaturesController(w http.ResponseWriter, r *http.Request)
 				Data: bytes,
 			}
 
-			c.store.NotifyClients(event, f.EnvironmentID)
+			c.notify(r.Context(), projectID, f.EnvironmentID, event)
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -81,29 +145,29 @@ func (c *controller) FeaturesController(w http.ResponseWriter, r *http.Request)
 		})
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func (c *controller) FeatureByIdController(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
 
 	switch r.Method {
-	case http.MethodOptions:
-		w.WriteHeader(http.StatusNoContent)
 	case http.MethodGet:
 		projectID := r.PathValue("projectId")
 		featureID := r.PathValue("featureId")
 
 		features, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
 		if err != nil {
-			http.Error(w, "Failed to get features", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to get features")
+			return
+		}
+		if len(features) == 0 {
+			apierror.Error(w, r, "Feature not found", http.StatusNotFound)
 			return
 		}
 
+		w.Header().Set("ETag", models.FeatureETag(features))
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(models.Response{
 			Data: features,
@@ -113,28 +177,90 @@ func (c *controller) FeatureByIdController(w http.ResponseWriter, r *http.Reques
 		featureID := r.PathValue("featureId")
 
 		var updateFeaturesRequest []*models.UpdateFeatureRequest
-		if err := json.NewDecoder(r.Body).Decode(&updateFeaturesRequest); err != nil {
-			log.Println("Error decoding request body:", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeBody(w, r, &updateFeaturesRequest) {
+			return
+		}
+
+		environments, err := c.conn.GetEnvironments(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting environments:", err)
+			apierror.FromError(w, r, err, "Failed to update feature")
+			return
+		}
+		if err := validation.UpdateFeatures(updateFeaturesRequest, environments); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
+			return
+		}
+
+		currentFeatures, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
+		if err != nil {
+			log.Println("Error getting feature:", err)
+			apierror.FromError(w, r, err, "Failed to update feature")
+			return
+		}
+		if len(currentFeatures) == 0 {
+			apierror.Error(w, r, "Feature not found", http.StatusNotFound)
+			return
+		}
+		if !checkFeatureUpdate(w, r, currentFeatures, updateFeaturesRequest) {
 			return
 		}
-		defer r.Body.Close()
 
-		if err := c.conn.UpdateFeatures(r.Context(), projectID, featureID, updateFeaturesRequest); err != nil {
+		// updates to environments that require approval become change
+		// requests, everything else is applied immediately
+		var directUpdates, approvalUpdates []*models.UpdateFeatureRequest
+		for _, updateFeatureRequest := range updateFeaturesRequest {
+			i := slices.IndexFunc(environments, func(e *models.Environment) bool {
+				return e.ID == updateFeatureRequest.EnvironmentID
+			})
+			if i >= 0 && environments[i].RequiresApproval {
+				approvalUpdates = append(approvalUpdates, updateFeatureRequest)
+			} else {
+				directUpdates = append(directUpdates, updateFeatureRequest)
+			}
+		}
+
+		principal, _ := db.PrincipalFromContext(r.Context())
+		changeRequests, err := c.conn.UpdateFeatures(r.Context(), projectID, featureID, directUpdates, approvalUpdates, principal)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Feature not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			log.Println("Error updating feature:", err)
-			http.Error(w, "Failed to update feature", http.StatusInternalServerError)
+			writeFeatureError(w, r, err, "Failed to update feature")
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		result := models.UpdateFeaturesResult{
+			Applied:        make([]string, 0, len(directUpdates)),
+			ChangeRequests: changeRequests,
+		}
+		for _, updateFeatureRequest := range directUpdates {
+			result.Applied = append(result.Applied, updateFeatureRequest.EnvironmentID)
+		}
 
 		newlyUpdatedFeatures, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
 		if err != nil {
 			log.Println("Error getting new feature:", err)
+		} else {
+			w.Header().Set("ETag", models.FeatureETag(newlyUpdatedFeatures))
+		}
+
+		if len(result.ChangeRequests) > 0 {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(models.Response{
+			Data: result,
+		})
+
+		if len(directUpdates) == 0 || err != nil {
 			return
 		}
 
-		for _, updateFeatureRequest := range updateFeaturesRequest {
+		for _, updateFeatureRequest := range directUpdates {
 			i := slices.IndexFunc(newlyUpdatedFeatures, func(f *models.Feature) bool {
 				return f.EnvironmentID == updateFeatureRequest.EnvironmentID
 			})
@@ -148,17 +274,43 @@ func (c *controller) FeatureByIdController(w http.ResponseWriter, r *http.Reques
 				Data: bytes,
 			}
 
-			c.store.NotifyClients(event, updateFeatureRequest.EnvironmentID)
+			c.notify(r.Context(), projectID, updateFeatureRequest.EnvironmentID, event)
 		}
 	case http.MethodDelete:
 		projectID := r.PathValue("projectId")
 		featureID := r.PathValue("featureId")
 
-		existingFeature, _ := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			apierror.Error(w, r, "Deleting a feature requires an If-Match header", http.StatusPreconditionRequired)
+			return
+		}
 
-		if err := c.conn.DeleteFeature(r.Context(), projectID, featureID); err != nil {
+		existingFeature, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
+		if err != nil {
+			log.Println("Error getting feature:", err)
+			apierror.FromError(w, r, err, "Failed to delete feature")
+			return
+		}
+		if len(existingFeature) == 0 {
+			apierror.Error(w, r, "Feature not found", http.StatusNotFound)
+			return
+		}
+
+		// the tag is checked again when deleting in case the feature changes
+		// in between, "*" deletes whatever the feature is by then
+		etag := models.FeatureETag(existingFeature)
+		if !matchesETag(ifMatch, etag) {
+			writeFeatureError(w, r, &db.PreconditionFailedError{Message: "Feature changed since it was read", Current: existingFeature}, "")
+			return
+		}
+		if strings.TrimSpace(ifMatch) == "*" {
+			etag = ""
+		}
+
+		if err := c.conn.DeleteFeature(r.Context(), projectID, featureID, etag); err != nil {
 			log.Println("Error deleting feature:", err)
-			http.Error(w, "Failed to delete feature", http.StatusInternalServerError)
+			writeFeatureError(w, r, err, "Failed to delete feature")
 			return
 		}
 
@@ -171,7 +323,153 @@ func (c *controller) FeatureByIdController(w http.ResponseWriter, r *http.Reques
 				Data: bytes,
 			}
 
-			c.store.NotifyClients(event, feature.EnvironmentID)
+			c.notify(r.Context(), projectID, feature.EnvironmentID, event)
 		}
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) FeatureClientSideController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPut:
+		projectID := r.PathValue("projectId")
+		featureID := r.PathValue("featureId")
+
+		var updateFeatureClientSideRequest models.UpdateFeatureClientSideRequest
+		if !decodeBody(w, r, &updateFeatureClientSideRequest) {
+			return
+		}
+
+		if err := c.conn.UpdateFeatureClientSide(r.Context(), projectID, featureID, updateFeatureClientSideRequest.ClientSideAvailable); err != nil {
+			log.Println("Error updating feature:", err)
+			apierror.FromError(w, r, err, "Failed to update feature")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		updatedFeatures, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
+		if err != nil {
+			log.Println("Error getting updated feature:", err)
+			return
+		}
+
+		for _, feature := range updatedFeatures {
+			bytes, _ := json.Marshal(feature)
+			event := models.Event{
+				Type: "feature_updated",
+				Data: bytes,
+			}
+
+			c.notify(r.Context(), projectID, feature.EnvironmentID, event)
+		}
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// FeatureMetadataController updates the name, description, tags, owner,
+// maintainer, custom properties and links of a feature in all of its
+// environments
+func (c *controller) FeatureMetadataController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPut:
+		projectID := r.PathValue("projectId")
+		featureID := r.PathValue("featureId")
+
+		var updateFeatureMetadataRequest models.UpdateFeatureMetadataRequest
+		if !decodeBody(w, r, &updateFeatureMetadataRequest) {
+			return
+		}
+		if err := validation.UpdateFeatureMetadata(&updateFeatureMetadataRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
+			return
+		}
+
+		if err := c.conn.UpdateFeatureMetadata(r.Context(), projectID, featureID, &updateFeatureMetadataRequest); err != nil {
+			log.Println("Error updating feature metadata:", err)
+			apierror.FromError(w, r, err, "Failed to update feature")
+			return
+		}
+
+		updatedFeatures, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
+		if err != nil {
+			log.Println("Error getting updated feature:", err)
+			apierror.FromError(w, r, err, "Failed to get features")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: updatedFeatures,
+		})
+
+		for _, feature := range updatedFeatures {
+			bytes, _ := json.Marshal(feature)
+			event := models.Event{
+				Type: models.WebhookEventFeatureMetadataUpdated,
+				Data: bytes,
+			}
+
+			c.notify(r.Context(), projectID, feature.EnvironmentID, event)
+		}
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// FeatureByKeyController returns a feature in every environment by the key
+// SDKs evaluate it by
+func (c *controller) FeatureByKeyController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		key := r.PathValue("key")
+
+		features, err := c.conn.GetFeaturesByKey(r.Context(), projectID, key)
+		if err != nil {
+			apierror.FromError(w, r, err, "Failed to get features")
+			return
+		}
+		if len(features) == 0 {
+			apierror.Error(w, r, "Feature not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: features,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// FeatureActionsController routes features/{featureId}/{action}. The mux
+// rejects features/by-key/{key} next to features/{featureId}/client-side as
+// conflicting patterns, so lookups by key are served from here as well.
+func (c *controller) FeatureActionsController(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("featureId") == "by-key" {
+		r.SetPathValue("key", r.PathValue("action"))
+		c.FeatureByKeyController(w, r)
+		return
+	}
+
+	switch r.PathValue("action") {
+	case "client-side":
+		c.FeatureClientSideController(w, r)
+	case "metadata":
+		c.FeatureMetadataController(w, r)
+	case "restore":
+		c.RestoreFeatureController(w, r)
+	default:
+		apierror.Error(w, r, "Not found", http.StatusNotFound)
 	}
 }
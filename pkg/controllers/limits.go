@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+)
+
+func (c *controller) ProjectLimitsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		overrides, err := c.conn.GetProjectLimits(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting project limits:", err)
+			apierror.FromError(w, r, err, "Failed to get project limits")
+			return
+		}
+
+		projectLimits := &models.ProjectLimits{
+			ProjectID: projectID,
+			Effective: c.limiter.Defaults().Merge(overrides),
+		}
+		if overrides != nil {
+			projectLimits.Overrides = *overrides
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: projectLimits,
+		})
+	case http.MethodPut:
+		projectID := r.PathValue("projectId")
+
+		var limits models.Limits
+		if !decodeBody(w, r, &limits) {
+			return
+		}
+
+		// a non-positive rate disables the limit, which only the global
+		// configuration may do
+		for _, limit := range []*models.RateLimit{limits.APIToken, limits.SDKKey} {
+			if limit != nil && (limit.Rate <= 0 || limit.Burst < 0) {
+				apierror.Error(w, r, "rate must be positive and burst must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+		for _, quota := range []*int{limits.MaxConnectionsPerEnvironment, limits.MaxConnectionsPerApp} {
+			if quota != nil && *quota < 0 {
+				apierror.Error(w, r, "connection quotas must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+		if principal, _ := db.PrincipalFromContext(r.Context()); principal.Scope != models.TokenScopeOrganization && exceedsLimits(c.limiter.Defaults(), limits) {
+			apierror.Error(w, r, "Only organization tokens can raise limits above the global ones", http.StatusForbidden)
+			return
+		}
+
+		if err := c.conn.SetProjectLimits(r.Context(), projectID, &limits); err != nil {
+			log.Println("Error setting project limits:", err)
+			apierror.FromError(w, r, err, "Failed to set project limits")
+			return
+		}
+		c.limiter.Invalidate(projectID)
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		projectID := r.PathValue("projectId")
+
+		if err := c.conn.DeleteProjectLimits(r.Context(), projectID); err != nil {
+			log.Println("Error deleting project limits:", err)
+			apierror.FromError(w, r, err, "Failed to delete project limits")
+			return
+		}
+		c.limiter.Invalidate(projectID)
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// exceedsLimits reports whether any override is looser than the global
+// limits, a zero connection quota is unlimited
+func exceedsLimits(defaults, overrides models.Limits) bool {
+	for _, pair := range [][2]*models.RateLimit{{defaults.APIToken, overrides.APIToken}, {defaults.SDKKey, overrides.SDKKey}} {
+		global, override := pair[0], pair[1]
+		if override != nil && global.Rate > 0 && (override.Rate > global.Rate || override.Burst > global.Burst) {
+			return true
+		}
+	}
+	for _, pair := range [][2]*int{{defaults.MaxConnectionsPerEnvironment, overrides.MaxConnectionsPerEnvironment}, {defaults.MaxConnectionsPerApp, overrides.MaxConnectionsPerApp}} {
+		global, override := *pair[0], pair[1]
+		if override != nil && global > 0 && (*override == 0 || *override > global) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"modulyn/pkg/models"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+// parseListOptions reads the limit, cursor, sort and order query parameters
+// shared by the list endpoints
+func parseListOptions(query url.Values) (models.ListOptions, error) {
+	options := models.ListOptions{
+		Limit:  defaultPageSize,
+		Cursor: query.Get("cursor"),
+		Sort:   models.SortName,
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return options, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		options.Limit = limit
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "":
+	case models.SortName, models.SortCreated, models.SortUpdated:
+		options.Sort = sort
+	default:
+		return options, fmt.Errorf("sort must be one of name, created or updated")
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		options.Descending = true
+	default:
+		return options, fmt.Errorf("order must be asc or desc")
+	}
+
+	return options, nil
+}
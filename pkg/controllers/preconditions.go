@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// matchesETag reports whether an If-Match header lists the entity tag, "*"
+// matching any. Weak tags never match since If-Match compares strongly.
+func matchesETag(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFeatureUpdate holds an update to the feature as it was read, either
+// through the If-Match header or a version per environment. The versions
+// the header implies are filled in so the update only applies to the rows
+// that were read.
+func checkFeatureUpdate(w http.ResponseWriter, r *http.Request, current []*models.Feature, updateFeaturesRequest []*models.UpdateFeatureRequest) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" && slices.ContainsFunc(updateFeaturesRequest, func(u *models.UpdateFeatureRequest) bool {
+		return u.Version == nil
+	}) {
+		apierror.Error(w, r, "Updating a feature requires an If-Match header or a version for every environment", http.StatusPreconditionRequired)
+		return false
+	}
+
+	if ifMatch != "" && !matchesETag(ifMatch, models.FeatureETag(current)) {
+		writeFeatureError(w, r, &db.PreconditionFailedError{Message: "Feature changed since it was read", Current: current}, "")
+		return false
+	}
+
+	for _, updateFeatureRequest := range updateFeaturesRequest {
+		i := slices.IndexFunc(current, func(f *models.Feature) bool {
+			return f.EnvironmentID == updateFeatureRequest.EnvironmentID
+		})
+		if i < 0 {
+			continue
+		}
+
+		if updateFeatureRequest.Version == nil {
+			version := current[i].Version
+			updateFeatureRequest.Version = &version
+		} else if *updateFeatureRequest.Version != current[i].Version {
+			writeFeatureError(w, r, &db.PreconditionFailedError{
+				Message: fmt.Sprintf("Feature changed in environment %s since version %d", updateFeatureRequest.EnvironmentID, *updateFeatureRequest.Version),
+				Current: current,
+			}, "")
+			return false
+		}
+	}
+	return true
+}
+
+// writeFeatureError writes err like apierror.FromError, a failed
+// precondition carries the ETag of the current feature as well
+func writeFeatureError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	var preconditionError *db.PreconditionFailedError
+	if errors.As(err, &preconditionError) {
+		if current, ok := preconditionError.Current.([]*models.Feature); ok {
+			w.Header().Set("ETag", models.FeatureETag(current))
+		}
+	}
+	apierror.FromError(w, r, err, fallback)
+}
@@ -2,44 +2,68 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
 	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
 	"net/http"
 )
 
 func (c *controller) ProjectsController(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
 
 	switch r.Method {
-	case http.MethodOptions:
-		w.WriteHeader(http.StatusNoContent)
 	case http.MethodGet:
-		projects, err := c.conn.GetProjects(r.Context())
+		listOptions, err := parseListOptions(r.URL.Query())
+		if err != nil {
+			apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter := models.ProjectFilter{
+			ListOptions: listOptions,
+		}
+		if principal, _ := db.PrincipalFromContext(r.Context()); principal.Scope != models.TokenScopeOrganization {
+			filter.ProjectIDs = []string{principal.ProjectID}
+		}
+
+		projects, next, err := c.conn.GetProjects(r.Context(), &filter)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			apierror.Error(w, r, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			log.Println("Error getting projects:", err)
-			http.Error(w, "Failed to get projects", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to get projects")
 			return
 		}
+
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(models.Response{
 			Data: projects,
+			Next: next,
 		})
 	case http.MethodPost:
+		if principal, _ := db.PrincipalFromContext(r.Context()); principal.Scope != models.TokenScopeOrganization {
+			apierror.Error(w, r, "Creating projects requires an organization token", http.StatusForbidden)
+			return
+		}
+
 		var createProjectRequest models.CreateProjectRequest
-		if err := json.NewDecoder(r.Body).Decode(&createProjectRequest); err != nil {
-			log.Println("Error decoding request body:", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeBody(w, r, &createProjectRequest) {
+			return
+		}
+		if err := validation.CreateProject(&createProjectRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
 			return
 		}
-		defer r.Body.Close()
 
 		projectID, err := c.conn.CreateProject(r.Context(), &createProjectRequest)
 		if err != nil {
 			log.Println("Error creating project:", err)
-			http.Error(w, "Failed to create project", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to create project")
 			return
 		}
 
@@ -48,32 +72,47 @@ func (c *controller) ProjectsController(w http.ResponseWriter, r *http.Request)
 			Data: projectID,
 		})
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func (c *controller) ProjectByIdControllers(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
 
 	switch r.Method {
-	case http.MethodOptions:
-		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		details, err := c.conn.GetProjectDetails(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting project:", err)
+			apierror.FromError(w, r, err, "Failed to get project")
+			return
+		}
+
+		for _, environment := range details.Environments {
+			environment.ConnectedSDKs = c.store.ConnectedClients(environment.ID)
+			details.ConnectedSDKs += environment.ConnectedSDKs
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: details,
+		})
 	case http.MethodPut:
 		projectID := r.PathValue("projectId")
 		var updateProjectRequest models.UpdateProjectRequest
-		if err := json.NewDecoder(r.Body).Decode(&updateProjectRequest); err != nil {
-			log.Println("Error decoding request body:", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeBody(w, r, &updateProjectRequest) {
+			return
+		}
+		if err := validation.UpdateProject(&updateProjectRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
 			return
 		}
-		defer r.Body.Close()
 
 		if err := c.conn.UpdateProject(r.Context(), projectID, &updateProjectRequest); err != nil {
 			log.Println("Error updating project:", err)
-			http.Error(w, "Failed to update project", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to update project")
 			return
 		}
 
@@ -83,12 +122,12 @@ func (c *controller) ProjectByIdControllers(w http.ResponseWriter, r *http.Reque
 
 		if err := c.conn.DeleteProject(r.Context(), projectID); err != nil {
 			log.Println("Error deleting project:", err)
-			http.Error(w, "Failed to delete project", http.StatusInternalServerError)
+			apierror.FromError(w, r, err, "Failed to delete project")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
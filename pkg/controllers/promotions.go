@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
+	"net/http"
+	"slices"
+)
+
+// PromotionPreviewController shows what promoting features between two
+// environments would change without applying it
+func (c *controller) PromotionPreviewController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+
+		promotionRequest, _, ok := c.decodePromotion(w, r, projectID)
+		if !ok {
+			return
+		}
+
+		promotion, err := c.conn.GetPromotion(r.Context(), projectID, promotionRequest)
+		if err != nil {
+			log.Println("Error getting promotion:", err)
+			apierror.FromError(w, r, err, "Failed to preview promotion")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: promotion,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PromotionsController promotes features from one environment to another.
+// The changes are applied together, or become change requests when the
+// target environment requires approval.
+func (c *controller) PromotionsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+
+		promotionRequest, target, ok := c.decodePromotion(w, r, projectID)
+		if !ok {
+			return
+		}
+
+		principal, _ := db.PrincipalFromContext(r.Context())
+		promotion, err := c.conn.ApplyPromotion(r.Context(), projectID, promotionRequest, target.RequiresApproval, principal)
+		if err != nil {
+			log.Println("Error applying promotion:", err)
+			apierror.FromError(w, r, err, "Failed to apply promotion")
+			return
+		}
+
+		if len(promotion.ChangeRequests) > 0 {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(models.Response{
+			Data: promotion,
+		})
+
+		if !promotion.Applied {
+			return
+		}
+
+		features, err := c.conn.GetFeaturesByEnvironmentID(r.Context(), target.ID)
+		if err != nil {
+			log.Println("Error getting promoted features:", err)
+			return
+		}
+
+		for _, feature := range features {
+			if !slices.ContainsFunc(promotion.Changes, func(change *models.PromotionChange) bool {
+				return change.FeatureID == feature.ID
+			}) {
+				continue
+			}
+
+			bytes, _ := json.Marshal(feature)
+			event := models.Event{
+				Type: "feature_updated",
+				Data: bytes,
+			}
+
+			c.notify(r.Context(), projectID, target.ID, event)
+		}
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// decodePromotion reads and validates a promotion request and returns the
+// target environment
+func (c *controller) decodePromotion(w http.ResponseWriter, r *http.Request, projectID string) (*models.PromotionRequest, *models.Environment, bool) {
+	var promotionRequest models.PromotionRequest
+	if !decodeBody(w, r, &promotionRequest) {
+		return nil, nil, false
+	}
+
+	environments, err := c.conn.GetEnvironments(r.Context(), projectID)
+	if err != nil {
+		log.Println("Error getting environments:", err)
+		apierror.FromError(w, r, err, "Failed to get environments")
+		return nil, nil, false
+	}
+	if err := validation.Promotion(&promotionRequest, environments); err != nil {
+		apierror.FromError(w, r, err, "Invalid request")
+		return nil, nil, false
+	}
+
+	i := slices.IndexFunc(environments, func(e *models.Environment) bool {
+		return e.ID == promotionRequest.TargetEnvironmentID
+	})
+	return &promotionRequest, environments[i], true
+}
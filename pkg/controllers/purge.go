@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
+	"net/http"
+	"time"
+)
+
+// PurgeController permanently removes soft deleted data older than the
+// retention period on demand, the body is optional
+func (c *controller) PurgeController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		if principal, _ := db.PrincipalFromContext(r.Context()); principal.Scope != models.TokenScopeOrganization {
+			apierror.Error(w, r, "Purging requires an organization token", http.StatusForbidden)
+			return
+		}
+
+		var purgeRequest models.PurgeRequest
+		if !decodeOptionalBody(w, r, &purgeRequest) {
+			return
+		}
+		if err := validation.Purge(&purgeRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
+			return
+		}
+
+		retention := c.purger.Retention()
+		if purgeRequest.RetentionDays != nil {
+			retention = time.Duration(*purgeRequest.RetentionDays) * 24 * time.Hour
+		}
+
+		report, err := c.purger.Purge(r.Context(), retention, purgeRequest.DryRun)
+		if err != nil {
+			log.Println("Error purging deleted data:", err)
+			apierror.FromError(w, r, err, "Failed to purge deleted data")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: report,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+)
+
+func (c *controller) RolesController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		grants, err := c.conn.GetRoleGrants(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting role grants:", err)
+			apierror.FromError(w, r, err, "Failed to get roles")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: grants,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) RoleByTokenIdController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPut:
+		projectID := r.PathValue("projectId")
+		tokenID := r.PathValue("tokenId")
+
+		var grantRoleRequest models.GrantRoleRequest
+		if !decodeBody(w, r, &grantRoleRequest) {
+			return
+		}
+
+		if !models.IsValidRole(grantRoleRequest.Role) {
+			apierror.Error(w, r, "role must be one of viewer, editor or admin", http.StatusBadRequest)
+			return
+		}
+
+		token, err := c.conn.GetToken(r.Context(), tokenID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Token not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error getting token:", err)
+			apierror.FromError(w, r, err, "Failed to grant role")
+			return
+		}
+		if token.Scope == models.TokenScopeProject && token.ProjectID != projectID {
+			apierror.Error(w, r, "Token is scoped to a different project", http.StatusBadRequest)
+			return
+		}
+
+		if err := c.conn.GrantRole(r.Context(), projectID, tokenID, grantRoleRequest.Role); err != nil {
+			log.Println("Error granting role:", err)
+			apierror.FromError(w, r, err, "Failed to grant role")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		projectID := r.PathValue("projectId")
+		tokenID := r.PathValue("tokenId")
+
+		if err := c.conn.RevokeRole(r.Context(), projectID, tokenID); err != nil {
+			log.Println("Error revoking role:", err)
+			apierror.FromError(w, r, err, "Failed to revoke role")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
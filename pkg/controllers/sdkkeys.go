@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+	"time"
+)
+
+func (c *controller) SDKKeysController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		environmentID := r.PathValue("environmentId")
+
+		keys, err := c.conn.GetSDKKeys(r.Context(), projectID, environmentID)
+		if err != nil {
+			log.Println("Error getting sdk keys:", err)
+			apierror.FromError(w, r, err, "Failed to get sdk keys")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: keys,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) RotateSDKKeyController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		environmentID := r.PathValue("environmentId")
+
+		var rotateSDKKeyRequest models.RotateSDKKeyRequest
+		// the body is optional, an empty one rotates without a grace period
+		if !decodeOptionalBody(w, r, &rotateSDKKeyRequest) {
+			return
+		}
+
+		var gracePeriod time.Duration
+		if rotateSDKKeyRequest.GracePeriod != "" {
+			var err error
+			gracePeriod, err = time.ParseDuration(rotateSDKKeyRequest.GracePeriod)
+			if err != nil || gracePeriod < 0 {
+				apierror.Error(w, r, "gracePeriod must be a non-negative duration such as 24h", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if rotateSDKKeyRequest.Kind == "" {
+			rotateSDKKeyRequest.Kind = models.SDKKeyKindServer
+		}
+		if rotateSDKKeyRequest.Kind != models.SDKKeyKindServer && rotateSDKKeyRequest.Kind != models.SDKKeyKindClient {
+			apierror.Error(w, r, "kind must be server or client", http.StatusBadRequest)
+			return
+		}
+
+		environment, err := c.conn.GetEnvironment(r.Context(), projectID, environmentID)
+		if err != nil {
+			log.Println("Error getting environment:", err)
+			apierror.FromError(w, r, err, "Failed to rotate sdk key")
+			return
+		}
+		if environment.ID == "" {
+			apierror.Error(w, r, "Environment not found", http.StatusNotFound)
+			return
+		}
+
+		key, expiringKeyIDs, err := c.conn.RotateSDKKey(r.Context(), projectID, environmentID, rotateSDKKeyRequest.Kind, gracePeriod)
+		if err != nil {
+			log.Println("Error rotating sdk key:", err)
+			apierror.FromError(w, r, err, "Failed to rotate sdk key")
+			return
+		}
+
+		// subscribers on the previous keys are dropped once the grace period ends
+		time.AfterFunc(gracePeriod, func() {
+			for _, keyID := range expiringKeyIDs {
+				if n := c.store.DisconnectKey(keyID); n > 0 {
+					log.Printf("Disconnected %d clients using expired sdk key %s", n, keyID)
+				}
+			}
+		})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: key,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) SDKKeyByIdController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodDelete:
+		projectID := r.PathValue("projectId")
+		environmentID := r.PathValue("environmentId")
+		keyID := r.PathValue("keyId")
+
+		err := c.conn.RevokeSDKKey(r.Context(), projectID, environmentID, keyID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "SDK key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error revoking sdk key:", err)
+			apierror.FromError(w, r, err, "Failed to revoke sdk key")
+			return
+		}
+
+		if n := c.store.DisconnectKey(keyID); n > 0 {
+			log.Printf("Disconnected %d clients using revoked sdk key %s", n, keyID)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"modulyn/pkg/spec"
+	"modulyn/pkg/validation"
+	"net/http"
+	"slices"
+)
+
+// SpecPlanController shows the changes applying the YAML or JSON spec in
+// the body would make to the project
+func (c *controller) SpecPlanController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+
+		plan, _, _, ok := c.planSpec(w, r, projectID)
+		if !ok {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: plan,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SpecApplyController plans the spec in the body against the project and
+// applies every change in one transaction. The fingerprint query parameter
+// must be the one of the plan that was reviewed, a project that changed
+// since gives a different plan and is rejected.
+func (c *controller) SpecApplyController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+
+		fingerprint := r.URL.Query().Get("fingerprint")
+		if fingerprint == "" {
+			apierror.Error(w, r, "Applying a spec requires the fingerprint of its plan", http.StatusPreconditionRequired)
+			return
+		}
+
+		plan, environments, features, ok := c.planSpec(w, r, projectID)
+		if !ok {
+			return
+		}
+		if plan.Fingerprint != fingerprint {
+			apierror.Error(w, r, "The project or the spec changed since the plan was made, plan again", http.StatusConflict)
+			return
+		}
+
+		if len(plan.Changes) > 0 {
+			principal, _ := db.PrincipalFromContext(r.Context())
+			changeRequests, err := c.conn.ApplySpecPlan(r.Context(), projectID, plan, principal)
+			if err != nil {
+				log.Println("Error applying spec:", err)
+				apierror.FromError(w, r, err, "Failed to apply spec")
+				return
+			}
+			plan.Applied = true
+			plan.ChangeRequests = changeRequests
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: plan,
+		})
+
+		if plan.Applied {
+			c.announceSpec(r.Context(), projectID, plan, environments, features)
+		}
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// planSpec reads and validates the spec in the body and plans it against
+// the live environments and features, which it returns as well
+func (c *controller) planSpec(w http.ResponseWriter, r *http.Request, projectID string) (*models.SpecPlan, []*models.Environment, []*models.Feature, bool) {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return nil, nil, nil, false
+	}
+
+	flagSpec, err := spec.Parse(body)
+	if err != nil {
+		apierror.Error(w, r, "Spec is not valid: "+err.Error(), http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	if err := validation.FlagSpec(flagSpec); err != nil {
+		apierror.FromError(w, r, err, "Invalid request")
+		return nil, nil, nil, false
+	}
+
+	if _, err := c.conn.GetProject(r.Context(), projectID); err != nil {
+		apierror.FromError(w, r, err, "Failed to get project")
+		return nil, nil, nil, false
+	}
+
+	environments, err := c.conn.GetEnvironments(r.Context(), projectID)
+	if err != nil {
+		log.Println("Error getting environments:", err)
+		apierror.FromError(w, r, err, "Failed to get environments")
+		return nil, nil, nil, false
+	}
+
+	features, _, err := c.conn.GetFeatures(r.Context(), projectID, &models.FeatureFilter{})
+	if err != nil {
+		log.Println("Error getting features:", err)
+		apierror.FromError(w, r, err, "Failed to get features")
+		return nil, nil, nil, false
+	}
+
+	return spec.Plan(flagSpec, environments, features), environments, features, true
+}
+
+// announceSpec sends the environments and features an applied plan
+// changed to SDKs and webhooks, deleted ones as they were before
+func (c *controller) announceSpec(ctx context.Context, projectID string, plan *models.SpecPlan, environments []*models.Environment, features []*models.Feature) {
+	updatedEnvironments, err := c.conn.GetEnvironments(ctx, projectID)
+	if err != nil {
+		log.Println("Error getting applied environments:", err)
+		return
+	}
+	updatedFeatures, _, err := c.conn.GetFeatures(ctx, projectID, &models.FeatureFilter{})
+	if err != nil {
+		log.Println("Error getting applied features:", err)
+		return
+	}
+
+	for _, change := range plan.Changes {
+		switch change.Resource {
+		case models.SpecResourceEnvironment:
+			switch change.Action {
+			case models.SpecActionCreate:
+				for _, environment := range updatedEnvironments {
+					if environment.Name == change.Key && !slices.ContainsFunc(environments, func(e *models.Environment) bool {
+						return e.ID == environment.ID
+					}) {
+						c.publishEnvironment(ctx, projectID, environment.ID, models.WebhookEventEnvironmentCreated, environment)
+					}
+				}
+			case models.SpecActionUpdate:
+				c.publishEnvironment(ctx, projectID, change.ID, models.WebhookEventEnvironmentUpdated, nil)
+			case models.SpecActionDelete:
+				i := slices.IndexFunc(environments, func(e *models.Environment) bool {
+					return e.ID == change.ID
+				})
+				if i >= 0 {
+					c.publishEnvironment(ctx, projectID, change.ID, models.WebhookEventEnvironmentDeleted, environments[i])
+				}
+			}
+		case models.SpecResourceFeature, models.SpecResourceFeatureState:
+			eventType := models.WebhookEventFeatureUpdated
+			rows := updatedFeatures
+			switch change.Action {
+			case models.SpecActionCreate:
+				eventType = models.WebhookEventFeatureCreated
+			case models.SpecActionDelete:
+				eventType = models.WebhookEventFeatureDeleted
+				rows = features
+			}
+
+			for _, feature := range rows {
+				if feature.Label != change.Key || (change.Environment != "" && feature.EnvironmentName != change.Environment) {
+					continue
+				}
+				// states in environments requiring approval were only
+				// proposed, they are announced once approved
+				if change.Resource == models.SpecResourceFeatureState && slices.ContainsFunc(environments, func(e *models.Environment) bool {
+					return e.ID == feature.EnvironmentID && e.RequiresApproval
+				}) {
+					continue
+				}
+
+				bytes, _ := json.Marshal(feature)
+				event := models.Event{
+					Type: eventType,
+					Data: bytes,
+				}
+
+				c.notify(ctx, projectID, feature.EnvironmentID, event)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/models"
+	"modulyn/pkg/validation"
+	"net/http"
+	"net/url"
+)
+
+// parseTagMatch reads the tagMatch query parameter shared by the feature
+// list and the event streams, it defaults to any
+func parseTagMatch(query url.Values) (string, error) {
+	switch tagMatch := query.Get("tagMatch"); tagMatch {
+	case "":
+		return models.TagMatchAny, nil
+	case models.TagMatchAny, models.TagMatchAll:
+		return tagMatch, nil
+	default:
+		return "", fmt.Errorf("tagMatch must be %s or %s", models.TagMatchAny, models.TagMatchAll)
+	}
+}
+
+// TagsController lists the tags of the project's features
+func (c *controller) TagsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		tags, err := c.conn.GetTags(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting tags:", err)
+			apierror.FromError(w, r, err, "Failed to get tags")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: tags,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// TagByNameController renames a tag or removes it from every feature. Tags
+// containing '/' are passed escaped, e.g. area%2Fcheckout.
+func (c *controller) TagByNameController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPut:
+		projectID := r.PathValue("projectId")
+		tag := r.PathValue("tag")
+
+		var renameTagRequest models.RenameTagRequest
+		if !decodeBody(w, r, &renameTagRequest) {
+			return
+		}
+		if err := validation.RenameTag(tag, &renameTagRequest); err != nil {
+			apierror.FromError(w, r, err, "Invalid request")
+			return
+		}
+
+		featureIDs, err := c.conn.RenameTag(r.Context(), projectID, tag, renameTagRequest.Name)
+		if err != nil {
+			log.Println("Error renaming tag:", err)
+			apierror.FromError(w, r, err, "Failed to rename tag")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		c.announceTagged(r.Context(), projectID, featureIDs)
+	case http.MethodDelete:
+		projectID := r.PathValue("projectId")
+		tag := r.PathValue("tag")
+
+		featureIDs, err := c.conn.DeleteTag(r.Context(), projectID, tag)
+		if err != nil {
+			log.Println("Error deleting tag:", err)
+			apierror.FromError(w, r, err, "Failed to delete tag")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		c.announceTagged(r.Context(), projectID, featureIDs)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// announceTagged sends the features whose tags changed to SDKs and webhooks
+func (c *controller) announceTagged(ctx context.Context, projectID string, featureIDs []string) {
+	for _, featureID := range featureIDs {
+		features, err := c.conn.GetFeaturesByID(ctx, projectID, featureID)
+		if err != nil {
+			log.Println("Error getting updated feature:", err)
+			return
+		}
+
+		for _, feature := range features {
+			bytes, _ := json.Marshal(feature)
+			event := models.Event{
+				Type: models.WebhookEventFeatureMetadataUpdated,
+				Data: bytes,
+			}
+
+			c.notify(ctx, projectID, feature.EnvironmentID, event)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+	"time"
+)
+
+func (c *controller) TokensController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := db.PrincipalFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.URL.Query().Get("projectId")
+		if principal.Scope == models.TokenScopeProject {
+			if projectID != "" && projectID != principal.ProjectID {
+				apierror.Error(w, r, "Token is not scoped to this project", http.StatusForbidden)
+				return
+			}
+			projectID = principal.ProjectID
+		}
+
+		tokens, err := c.conn.GetTokens(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting tokens:", err)
+			apierror.FromError(w, r, err, "Failed to get tokens")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: tokens,
+		})
+	case http.MethodPost:
+		var createTokenRequest models.CreateTokenRequest
+		if !decodeBody(w, r, &createTokenRequest) {
+			return
+		}
+
+		// project scoped tokens can only mint tokens for their own project
+		if principal.Scope == models.TokenScopeProject {
+			if createTokenRequest.ProjectID == "" {
+				createTokenRequest.ProjectID = principal.ProjectID
+			}
+			if createTokenRequest.ProjectID != principal.ProjectID {
+				apierror.Error(w, r, "Token is not scoped to this project", http.StatusForbidden)
+				return
+			}
+		}
+
+		if createTokenRequest.Role == "" {
+			createTokenRequest.Role = models.RoleViewer
+		}
+		if !models.IsValidRole(createTokenRequest.Role) {
+			apierror.Error(w, r, "role must be one of viewer, editor or admin", http.StatusBadRequest)
+			return
+		}
+
+		var expiresAt *time.Time
+		if createTokenRequest.ExpiresAt != "" {
+			t, err := time.Parse(time.RFC3339, createTokenRequest.ExpiresAt)
+			if err != nil || t.Before(time.Now()) {
+				apierror.Error(w, r, "expiresAt must be a future RFC3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			t = t.UTC()
+			expiresAt = &t
+		}
+
+		token, err := c.conn.CreateToken(r.Context(), &createTokenRequest, expiresAt)
+		if err != nil {
+			log.Println("Error creating token:", err)
+			apierror.FromError(w, r, err, "Failed to create token")
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: token,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) TokenByIdController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := db.PrincipalFromContext(r.Context())
+	tokenID := r.PathValue("tokenId")
+
+	token, err := c.conn.GetToken(r.Context(), tokenID)
+	if errors.Is(err, db.ErrNoRows) || (err == nil && !principal.CanAccessProject(token.ProjectID)) {
+		apierror.Error(w, r, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error getting token:", err)
+		apierror.FromError(w, r, err, "Failed to get token")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: token,
+		})
+	case http.MethodDelete:
+		if err := c.conn.DeleteToken(r.Context(), tokenID); err != nil {
+			log.Println("Error deleting token:", err)
+			apierror.FromError(w, r, err, "Failed to delete token")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
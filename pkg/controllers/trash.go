@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+)
+
+// ProjectTrashController lists the environments and features of a project
+// that can be restored
+func (c *controller) ProjectTrashController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		trash, err := c.conn.GetTrash(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting trash:", err)
+			apierror.FromError(w, r, err, "Failed to get trash")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: trash,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeletedProjectsController lists the deleted projects the token can see
+func (c *controller) DeletedProjectsController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		var projectIDs []string
+		if principal, _ := db.PrincipalFromContext(r.Context()); principal.Scope != models.TokenScopeOrganization {
+			projectIDs = []string{principal.ProjectID}
+		}
+
+		projects, err := c.conn.GetDeletedProjects(r.Context(), projectIDs)
+		if err != nil {
+			log.Println("Error getting deleted projects:", err)
+			apierror.FromError(w, r, err, "Failed to get deleted projects")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: projects,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) RestoreProjectController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+
+		if err := c.conn.RestoreProject(r.Context(), projectID); err != nil {
+			log.Println("Error restoring project:", err)
+			apierror.FromError(w, r, err, "Failed to restore project")
+			return
+		}
+
+		project, err := c.conn.GetProject(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting restored project:", err)
+			apierror.FromError(w, r, err, "Failed to get project")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: project,
+		})
+
+		features, _, err := c.conn.GetFeatures(r.Context(), projectID, &models.FeatureFilter{})
+		if err != nil {
+			log.Println("Error getting restored features:", err)
+			return
+		}
+		c.announceFeatures(r.Context(), projectID, features)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) RestoreEnvironmentController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		environmentID := r.PathValue("environmentId")
+
+		if err := c.conn.RestoreEnvironment(r.Context(), projectID, environmentID); err != nil {
+			log.Println("Error restoring environment:", err)
+			apierror.FromError(w, r, err, "Failed to restore environment")
+			return
+		}
+
+		environment, err := c.conn.GetEnvironment(r.Context(), projectID, environmentID)
+		if err != nil {
+			log.Println("Error getting restored environment:", err)
+			apierror.FromError(w, r, err, "Failed to get environment")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: environment,
+		})
+
+		c.publishEnvironment(r.Context(), projectID, environmentID, models.WebhookEventEnvironmentCreated, environment)
+
+		features, err := c.conn.GetFeaturesByEnvironmentID(r.Context(), environmentID)
+		if err != nil {
+			log.Println("Error getting restored features:", err)
+			return
+		}
+		c.announceFeatures(r.Context(), projectID, features)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) RestoreFeatureController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		featureID := r.PathValue("featureId")
+
+		if err := c.conn.RestoreFeature(r.Context(), projectID, featureID); err != nil {
+			log.Println("Error restoring feature:", err)
+			apierror.FromError(w, r, err, "Failed to restore feature")
+			return
+		}
+
+		features, err := c.conn.GetFeaturesByID(r.Context(), projectID, featureID)
+		if err != nil {
+			log.Println("Error getting restored feature:", err)
+			apierror.FromError(w, r, err, "Failed to get features")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: features,
+		})
+
+		c.announceFeatures(r.Context(), projectID, features)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// announceFeatures sends restored features to SDKs and webhooks as if they
+// had just been created
+func (c *controller) announceFeatures(ctx context.Context, projectID string, features []*models.Feature) {
+	for _, feature := range features {
+		bytes, _ := json.Marshal(feature)
+		event := models.Event{
+			Type: models.WebhookEventFeatureCreated,
+			Data: bytes,
+		}
+
+		c.notify(ctx, projectID, feature.EnvironmentID, event)
+	}
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+func (c *controller) WebhooksController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+
+		webhooks, err := c.conn.GetWebhooks(r.Context(), projectID)
+		if err != nil {
+			log.Println("Error getting webhooks:", err)
+			apierror.FromError(w, r, err, "Failed to get webhooks")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: webhooks,
+		})
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+
+		var createWebhookRequest models.CreateWebhookRequest
+		if !decodeBody(w, r, &createWebhookRequest) {
+			return
+		}
+
+		if err := c.webhooks.ValidateURL(r.Context(), createWebhookRequest.URL); err != nil {
+			apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateWebhookEventTypes(createWebhookRequest.EventTypes); err != nil {
+			apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		webhook, err := c.conn.CreateWebhook(r.Context(), projectID, &createWebhookRequest)
+		if err != nil {
+			log.Println("Error creating webhook:", err)
+			apierror.FromError(w, r, err, "Failed to create webhook")
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: webhook,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) WebhookByIdController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		webhookID := r.PathValue("webhookId")
+
+		webhook, err := c.conn.GetWebhook(r.Context(), projectID, webhookID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error getting webhook:", err)
+			apierror.FromError(w, r, err, "Failed to get webhook")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: webhook,
+		})
+	case http.MethodPut:
+		projectID := r.PathValue("projectId")
+		webhookID := r.PathValue("webhookId")
+
+		var updateWebhookRequest models.UpdateWebhookRequest
+		if !decodeBody(w, r, &updateWebhookRequest) {
+			return
+		}
+
+		if updateWebhookRequest.URL != nil {
+			if err := c.webhooks.ValidateURL(r.Context(), *updateWebhookRequest.URL); err != nil {
+				apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := validateWebhookEventTypes(updateWebhookRequest.EventTypes); err != nil {
+			apierror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := c.conn.UpdateWebhook(r.Context(), projectID, webhookID, &updateWebhookRequest)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error updating webhook:", err)
+			apierror.FromError(w, r, err, "Failed to update webhook")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		projectID := r.PathValue("projectId")
+		webhookID := r.PathValue("webhookId")
+
+		err := c.conn.DeleteWebhook(r.Context(), projectID, webhookID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error deleting webhook:", err)
+			apierror.FromError(w, r, err, "Failed to delete webhook")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) WebhookDeliveriesController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		projectID := r.PathValue("projectId")
+		webhookID := r.PathValue("webhookId")
+
+		limit := defaultDeliveriesLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 || parsed > maxDeliveriesLimit {
+				apierror.Error(w, r, fmt.Sprintf("limit must be between 1 and %d", maxDeliveriesLimit), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		if _, err := c.conn.GetWebhook(r.Context(), projectID, webhookID); errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Webhook not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error getting webhook:", err)
+			apierror.FromError(w, r, err, "Failed to get webhook deliveries")
+			return
+		}
+
+		deliveries, err := c.conn.GetWebhookDeliveries(r.Context(), webhookID, limit)
+		if err != nil {
+			log.Println("Error getting webhook deliveries:", err)
+			apierror.FromError(w, r, err, "Failed to get webhook deliveries")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: deliveries,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *controller) TestWebhookController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		projectID := r.PathValue("projectId")
+		webhookID := r.PathValue("webhookId")
+
+		webhook, err := c.conn.GetWebhook(r.Context(), projectID, webhookID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Error(w, r, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error getting webhook:", err)
+			apierror.FromError(w, r, err, "Failed to send test event")
+			return
+		}
+
+		delivery, err := c.webhooks.Test(r.Context(), webhook)
+		if err != nil {
+			log.Println("Error sending test event:", err)
+			apierror.FromError(w, r, err, "Failed to send test event")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.Response{
+			Data: delivery,
+		})
+	default:
+		apierror.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validateWebhookEventTypes(eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		if !slices.Contains(models.WebhookEventTypes, eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"modulyn/pkg/models"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ChangeRequestDB interface {
+	GetChangeRequests(ctx context.Context, projectID, status string) ([]*models.ChangeRequest, error)
+	GetChangeRequest(ctx context.Context, projectID, changeRequestID string) (*models.ChangeRequest, error)
+	ApplyChangeRequest(ctx context.Context, projectID, changeRequestID string, reviewer *models.Principal, comment string) (*models.ChangeRequest, error)
+	RejectChangeRequest(ctx context.Context, projectID, changeRequestID string, reviewer *models.Principal, comment string) (*models.ChangeRequest, error)
+}
+
+const selectChangeRequestsSQL = `
+	SELECT id, project_id, feature_id, environment_id, proposed, base, reason, status, author_token_id, author_name, reviewer_token_id, reviewer_name, review_comment, created_at, reviewed_at, from_spec
+	FROM change_requests
+`
+
+// insertChangeRequest records a pending change request, capturing the
+// current state of the feature so conflicting changes made before the
+// review can be detected
+func insertChangeRequest(ctx context.Context, tx *LoggerTx, projectID, featureID string, updateFeatureRequest *models.UpdateFeatureRequest, author *models.Principal) (*models.ChangeRequest, error) {
+	if err := rejectManagedFeature(ctx, tx, projectID, featureID); err != nil {
+		return nil, err
+	}
+	return recordChangeRequest(ctx, tx, projectID, featureID, updateFeatureRequest, author, false)
+}
+
+// recordChangeRequest inserts a pending change request without checking
+// whether the feature is managed, fromSpec lets a spec propose states of
+// the features it manages
+func recordChangeRequest(ctx context.Context, tx *LoggerTx, projectID, featureID string, updateFeatureRequest *models.UpdateFeatureRequest, author *models.Principal, fromSpec bool) (*models.ChangeRequest, error) {
+	base, err := currentFeatureState(ctx, tx, projectID, featureID, updateFeatureRequest.EnvironmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, _ := uuid.NewRandom()
+	proposedBytes, _ := json.Marshal(updateFeatureRequest)
+	baseBytes, _ := json.Marshal(base)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO change_requests
+		(id, project_id, feature_id, environment_id, proposed, base, reason, status, author_token_id, author_name, from_spec)
+		VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, newID.String(), projectID, featureID, updateFeatureRequest.EnvironmentID, proposedBytes, baseBytes, updateFeatureRequest.Reason, models.ChangeRequestPending, author.TokenID, author.Name, fromSpec)
+	if err != nil {
+		log.Println("Error inserting change request in database:", err)
+		return nil, err
+	}
+
+	return &models.ChangeRequest{
+		ID:            newID.String(),
+		ProjectID:     projectID,
+		FeatureID:     featureID,
+		EnvironmentID: updateFeatureRequest.EnvironmentID,
+		Proposed:      *updateFeatureRequest,
+		Base:          *base,
+		Reason:        updateFeatureRequest.Reason,
+		Status:        models.ChangeRequestPending,
+		AuthorTokenID: author.TokenID,
+		AuthorName:    author.Name,
+		FromSpec:      fromSpec,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+func (db *DB) GetChangeRequests(ctx context.Context, projectID, status string) ([]*models.ChangeRequest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, selectChangeRequestsSQL+`
+		WHERE project_id = ? AND (? = '' OR status = ?)
+		ORDER BY created_at DESC
+	`, projectID, status, status)
+	if err != nil {
+		log.Println("Error querying change requests from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	changeRequests := make([]*models.ChangeRequest, 0)
+
+	for rows.Next() {
+		changeRequest, err := scanChangeRequest(rows.Scan)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		changeRequests = append(changeRequests, changeRequest)
+	}
+
+	return changeRequests, nil
+}
+
+func (db *DB) GetChangeRequest(ctx context.Context, projectID, changeRequestID string) (*models.ChangeRequest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	return getChangeRequest(ctx, tx, projectID, changeRequestID)
+}
+
+// ApplyChangeRequest approves a pending change request and applies it in the
+// same transaction. When the feature changed since the request was submitted
+// the request is marked as conflicted and ErrConflict is returned.
+func (db *DB) ApplyChangeRequest(ctx context.Context, projectID, changeRequestID string, reviewer *models.Principal, comment string) (*models.ChangeRequest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	changeRequest, err := getChangeRequest(ctx, tx, projectID, changeRequestID)
+	if err != nil {
+		return nil, err
+	}
+	if changeRequest.Status != models.ChangeRequestPending {
+		return changeRequest, ErrNotPending
+	}
+
+	current, err := currentFeatureState(ctx, tx, projectID, changeRequest.FeatureID, changeRequest.EnvironmentID)
+	if err != nil && err != ErrNoRows {
+		return nil, err
+	}
+
+	status := models.ChangeRequestApplied
+	if current == nil || !sameFeatureState(current, &changeRequest.Base) {
+		status = models.ChangeRequestConflicted
+	}
+
+	// a feature that became managed as code after the request was made
+	// only changes through its spec, the request can never apply unless
+	// the spec proposed it
+	var conflictError *ConflictError
+	if !changeRequest.FromSpec {
+		managedErr := rejectManagedFeature(ctx, tx, projectID, changeRequest.FeatureID)
+		if errors.As(managedErr, &conflictError) {
+			status = models.ChangeRequestConflicted
+		} else if managedErr != nil {
+			err = managedErr
+			return nil, err
+		}
+	}
+
+	if status == models.ChangeRequestApplied {
+		jsonValueBytes, _ := json.Marshal(changeRequest.Proposed.JsonValue)
+		_, err = tx.ExecContext(ctx, `
+			UPDATE features
+			SET enabled = ?, json_value = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND environment_id = ? AND project_id = ?
+		`, changeRequest.Proposed.Enabled, jsonValueBytes, changeRequest.FeatureID, changeRequest.EnvironmentID, projectID)
+		if err != nil {
+			log.Println("Error applying change request in database:", err)
+			return nil, err
+		}
+	}
+
+	if err = reviewChangeRequest(ctx, tx, changeRequest, status, reviewer, comment); err != nil {
+		return nil, err
+	}
+
+	// the conflicted status is committed, the caller still learns about it
+	if conflictError != nil {
+		return changeRequest, conflictError
+	}
+	if status == models.ChangeRequestConflicted {
+		return changeRequest, ErrConflict
+	}
+	return changeRequest, nil
+}
+
+func (db *DB) RejectChangeRequest(ctx context.Context, projectID, changeRequestID string, reviewer *models.Principal, comment string) (*models.ChangeRequest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	changeRequest, err := getChangeRequest(ctx, tx, projectID, changeRequestID)
+	if err != nil {
+		return nil, err
+	}
+	if changeRequest.Status != models.ChangeRequestPending {
+		return changeRequest, ErrNotPending
+	}
+
+	if err = reviewChangeRequest(ctx, tx, changeRequest, models.ChangeRequestRejected, reviewer, comment); err != nil {
+		return nil, err
+	}
+
+	return changeRequest, nil
+}
+
+func reviewChangeRequest(ctx context.Context, tx *LoggerTx, changeRequest *models.ChangeRequest, status string, reviewer *models.Principal, comment string) error {
+	reviewedAt := time.Now().UTC()
+	_, err := tx.ExecContext(ctx, `
+		UPDATE change_requests
+		SET status = ?, reviewer_token_id = ?, reviewer_name = ?, review_comment = ?, reviewed_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, status, reviewer.TokenID, reviewer.Name, comment, reviewedAt, changeRequest.ID)
+	if err != nil {
+		log.Println("Error reviewing change request in database:", err)
+		return err
+	}
+
+	changeRequest.Status = status
+	changeRequest.ReviewerTokenID = reviewer.TokenID
+	changeRequest.ReviewerName = reviewer.Name
+	changeRequest.ReviewComment = comment
+	changeRequest.ReviewedAt = reviewedAt.Format(time.RFC3339)
+	return nil
+}
+
+func getChangeRequest(ctx context.Context, tx *LoggerTx, projectID, changeRequestID string) (*models.ChangeRequest, error) {
+	rows, err := tx.QueryContext(ctx, selectChangeRequestsSQL+`
+		WHERE project_id = ? AND id = ?
+	`, projectID, changeRequestID)
+	if err != nil {
+		log.Println("Error querying change request from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrNoRows
+	}
+
+	changeRequest, err := scanChangeRequest(rows.Scan)
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+	return changeRequest, nil
+}
+
+// currentFeatureState reads the enabled state and value of a live feature
+// in one environment
+func currentFeatureState(ctx context.Context, tx *LoggerTx, projectID, featureID, environmentID string) (*models.FeatureState, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT enabled, json_value
+		FROM features
+		WHERE id = ? AND environment_id = ? AND project_id = ? AND is_deleted = 0
+	`, featureID, environmentID, projectID)
+	if err != nil {
+		log.Println("Error querying feature from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrNoRows
+	}
+
+	var enabled bool
+	var jsonValue []byte
+	if err := rows.Scan(&enabled, &jsonValue); err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+
+	state := &models.FeatureState{
+		Enabled: enabled,
+	}
+	json.Unmarshal(jsonValue, &state.JsonValue)
+	return state, nil
+}
+
+func sameFeatureState(a, b *models.FeatureState) bool {
+	return a.Enabled == b.Enabled &&
+		a.JsonValue.Key == b.JsonValue.Key &&
+		a.JsonValue.Enabled == b.JsonValue.Enabled &&
+		slices.Equal(a.JsonValue.Values, b.JsonValue.Values)
+}
+
+func scanChangeRequest(scan func(dest ...any) error) (*models.ChangeRequest, error) {
+	var id, projectID, featureID, environmentID, status, authorTokenID, authorName string
+	var reason, reviewerTokenID, reviewerName, reviewComment *string
+	var proposed, base []byte
+	var createdAt time.Time
+	var reviewedAt *time.Time
+	var fromSpec bool
+
+	if err := scan(&id, &projectID, &featureID, &environmentID, &proposed, &base, &reason, &status, &authorTokenID, &authorName, &reviewerTokenID, &reviewerName, &reviewComment, &createdAt, &reviewedAt, &fromSpec); err != nil {
+		return nil, err
+	}
+
+	changeRequest := &models.ChangeRequest{
+		ID:            id,
+		ProjectID:     projectID,
+		FeatureID:     featureID,
+		EnvironmentID: environmentID,
+		Status:        status,
+		AuthorTokenID: authorTokenID,
+		AuthorName:    authorName,
+		FromSpec:      fromSpec,
+		CreatedAt:     createdAt.Format(time.RFC3339),
+	}
+	json.Unmarshal(proposed, &changeRequest.Proposed)
+	json.Unmarshal(base, &changeRequest.Base)
+	if reason != nil {
+		changeRequest.Reason = *reason
+	}
+	if reviewerTokenID != nil {
+		changeRequest.ReviewerTokenID = *reviewerTokenID
+	}
+	if reviewerName != nil {
+		changeRequest.ReviewerName = *reviewerName
+	}
+	if reviewComment != nil {
+		changeRequest.ReviewComment = *reviewComment
+	}
+	if reviewedAt != nil {
+		changeRequest.ReviewedAt = reviewedAt.Format(time.RFC3339)
+	}
+	return changeRequest, nil
+}
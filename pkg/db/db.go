@@ -1,28 +1,45 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"log"
+	"modulyn/pkg/models"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var (
-	ErrNoRows = errors.New("no results found")
-)
-
 var EnableSqlLogging = false
 
 type contextKey string
 
 const CorrelationKey contextKey = "correlation_id"
 
+const PrincipalKey contextKey = "principal"
+
+// PrincipalFromContext returns the authenticated caller attached by the auth middleware
+func PrincipalFromContext(ctx context.Context) (*models.Principal, bool) {
+	principal, ok := ctx.Value(PrincipalKey).(*models.Principal)
+	return principal, ok
+}
+
 type Conn interface {
 	Close() error
 	FeatureDB
 	ProjectDB
 	EnvironmentDB
+	TokenDB
+	RoleDB
+	SDKKeyDB
+	LimitsDB
+	ChangeRequestDB
+	WebhookDB
+	TrashDB
+	PurgeDB
+	PromotionDB
+	ExportDB
+	SpecDB
+	TagDB
 }
 
 type DB struct {
@@ -101,12 +118,251 @@ func InitDB(enableSqlLogging bool) (Conn, error) {
 	}
 	log.Println("Created features table")
 
+	// Create the api tokens table if it doesn't exist
+	createApiTokensTableSQL := `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			token_prefix TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			project_id TEXT,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			is_deleted INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createApiTokensTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created api tokens table")
+
+	// Create the role grants table if it doesn't exist
+	createRoleGrantsTableSQL := `
+		CREATE TABLE IF NOT EXISTS role_grants (
+			token_id TEXT NOT NULL,
+			project_id TEXT NOT NULL,
+			role TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (token_id, project_id),
+			FOREIGN KEY (token_id) REFERENCES api_tokens(id),
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createRoleGrantsTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created role grants table")
+
+	// Create the sdk keys table if it doesn't exist
+	createSDKKeysTableSQL := `
+		CREATE TABLE IF NOT EXISTS sdk_keys (
+			id TEXT PRIMARY KEY,
+			key TEXT NOT NULL UNIQUE,
+			environment_id TEXT NOT NULL,
+			project_id TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY (environment_id) REFERENCES environments(id),
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createSDKKeysTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created sdk keys table")
+
+	// Create the project limits table if it doesn't exist
+	createProjectLimitsTableSQL := `
+		CREATE TABLE IF NOT EXISTS project_limits (
+			project_id TEXT PRIMARY KEY,
+			limits BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createProjectLimitsTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created project limits table")
+
+	// Create the change requests table if it doesn't exist
+	createChangeRequestsTableSQL := `
+		CREATE TABLE IF NOT EXISTS change_requests (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			feature_id TEXT NOT NULL,
+			environment_id TEXT NOT NULL,
+			proposed BLOB NOT NULL,
+			base BLOB NOT NULL,
+			reason TEXT,
+			status TEXT NOT NULL,
+			author_token_id TEXT NOT NULL,
+			author_name TEXT NOT NULL,
+			reviewer_token_id TEXT,
+			reviewer_name TEXT,
+			review_comment TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			reviewed_at DATETIME,
+			FOREIGN KEY (environment_id) REFERENCES environments(id),
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createChangeRequestsTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created change requests table")
+
+	// Create the webhooks table if it doesn't exist
+	createWebhooksTableSQL := `
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			event_types BLOB NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			is_deleted INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createWebhooksTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created webhooks table")
+
+	// Create the webhook deliveries table if it doesn't exist
+	createWebhookDeliveriesTableSQL := `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			payload BLOB NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_status INTEGER,
+			error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			next_attempt_at DATETIME,
+			delivered_at DATETIME,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+		);
+	`
+	_, err = db.Exec(createWebhookDeliveriesTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created webhook deliveries table")
+
+	createFeatureTagsTableSQL := `
+		CREATE TABLE IF NOT EXISTS feature_tags (
+			feature_id TEXT NOT NULL,
+			project_id TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (feature_id, tag),
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		);
+	`
+	_, err = db.Exec(createFeatureTagsTableSQL)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created feature tags table")
+
+	// add columns introduced after the tables were first created
+	migrations := []struct {
+		table, column, definition string
+	}{
+		// tokens created before roles existed keep full access
+		{"api_tokens", "role", "TEXT NOT NULL DEFAULT 'admin'"},
+		{"environments", "required_role", "TEXT"},
+		{"features", "client_side_available", "INTEGER NOT NULL DEFAULT 0"},
+		{"sdk_keys", "kind", "TEXT NOT NULL DEFAULT 'server'"},
+		{"environments", "requires_approval", "INTEGER NOT NULL DEFAULT 0"},
+		{"features", "owner", "TEXT"},
+		{"features", "links", "BLOB"},
+		// deleted_with holds the project or environment whose deletion
+		// cascaded to the row, NULL when the row was deleted directly
+		{"environments", "deleted_with", "TEXT"},
+		{"features", "deleted_with", "TEXT"},
+		// managed features are owned by a flags-as-code spec and only
+		// change when the spec is applied
+		{"features", "managed", "INTEGER NOT NULL DEFAULT 0"},
+		{"features", "maintainer", "TEXT"},
+		// properties is a JSON object of free-form string values
+		{"features", "properties", "TEXT"},
+		// type is one of models.FeatureTypes, rows created before it
+		// existed are releases
+		{"features", "type", "TEXT NOT NULL DEFAULT 'release'"},
+		// version counts the changes to a feature row, see the
+		// feature_version trigger below
+		{"features", "version", "INTEGER NOT NULL DEFAULT 1"},
+		// from_spec marks change requests proposed by applying a spec,
+		// which may change features managed as code
+		{"change_requests", "from_spec", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, m := range migrations {
+		if err := addColumnIfNotExists(db, m.table, m.column, m.definition); err != nil {
+			return nil, err
+		}
+	}
+	log.Println("Migrated table columns")
+
+	// every update of a feature row bumps its version, so conditional
+	// requests notice changes made by any code path. Updates setting the
+	// version themselves are left alone, recursive triggers are off so the
+	// trigger's own update does not fire it again.
+	_, err = db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS feature_version AFTER UPDATE ON features
+		FOR EACH ROW WHEN NEW.version = OLD.version
+		BEGIN
+			UPDATE features SET version = OLD.version + 1 WHERE rowid = NEW.rowid;
+		END;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Created feature version trigger")
+
+	// feature keys were not unique before the index below existed
+	if err := dedupeFeatureKeys(db); err != nil {
+		return nil, err
+	}
+	log.Println("Deduplicated feature keys")
+
 	// create indices for the tables
 	createIndicesSQL := `
 		BEGIN;
 		CREATE INDEX IF NOT EXISTS idx_feature_project_id_environment_id ON features (project_id, environment_id);
 		CREATE INDEX IF NOT EXISTS idx_feature_updated_at ON features (updated_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_feature_project_id_environment_id_label ON features (project_id, environment_id, label) WHERE is_deleted = 0;
+		CREATE INDEX IF NOT EXISTS idx_feature_tag_project_id_tag ON feature_tags (project_id, tag);
 		CREATE INDEX IF NOT EXISTS idx_environment_project_id ON environments (project_id);
+		CREATE INDEX IF NOT EXISTS idx_api_token_project_id ON api_tokens (project_id);
+		CREATE INDEX IF NOT EXISTS idx_role_grant_project_id ON role_grants (project_id);
+		CREATE INDEX IF NOT EXISTS idx_sdk_key_environment_id ON sdk_keys (environment_id);
+		CREATE INDEX IF NOT EXISTS idx_change_request_project_id_status ON change_requests (project_id, status);
+		CREATE INDEX IF NOT EXISTS idx_webhook_project_id ON webhooks (project_id);
+		CREATE INDEX IF NOT EXISTS idx_webhook_delivery_webhook_id ON webhook_deliveries (webhook_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_webhook_delivery_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
 		COMMIT;
 	`
 	_, err = db.Exec(createIndicesSQL)
@@ -117,7 +373,14 @@ func InitDB(enableSqlLogging bool) (Conn, error) {
 
 	EnableSqlLogging = enableSqlLogging
 
-	return &DB{
+	conn := &DB{
 		db,
-	}, nil
+	}
+
+	if err := seedLegacySDKKeys(conn); err != nil {
+		return nil, err
+	}
+	log.Println("Seeded sdk keys for existing environments")
+
+	return conn, nil
 }
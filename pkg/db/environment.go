@@ -2,9 +2,11 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"modulyn/pkg/models"
+	"slices"
 
 	"github.com/google/uuid"
 )
@@ -15,6 +17,7 @@ type EnvironmentDB interface {
 	GetEnvironment(ctx context.Context, projectID, environmentID string) (*models.Environment, error)
 	UpdateEnvironment(ctx context.Context, projectID, environmentID string, updateEnvironmentRequest *models.UpdateEnvironmentRequest) error
 	DeleteEnvironment(ctx context.Context, projectID, environmentID string) error
+	CompareEnvironments(ctx context.Context, projectID, baseEnvironmentID, targetEnvironmentID string) (*models.EnvironmentComparison, error)
 }
 
 func (db *DB) CreateEnvironment(ctx context.Context, projectID string, createEnvironmentRequest *models.CreateEnvironmentRequest) (string, error) {
@@ -27,60 +30,73 @@ func (db *DB) CreateEnvironment(ctx context.Context, projectID string, createEnv
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	newEnvironmentId, _ := uuid.NewRandom()
-	sdkKey := fmt.Sprintf("sdk-%s", newEnvironmentId.String())
-
-	rows, err := tx.QueryContext(ctx, `
-		SELECT distinct f.id, f.name 
-		FROM features f 
-		WHERE f.project_id = ? AND f.is_deleted = 0
-	`, projectID)
+	environmentID, err := insertEnvironment(ctx, tx, projectID, createEnvironmentRequest.Name, nil, false)
 	if err != nil {
-		log.Println("Error querying features from database:", err)
 		return "", err
 	}
-	defer rows.Close()
 
-	var features []struct {
-		id   string
-		name string
-	}
-	for rows.Next() {
-		var feature struct {
-			id   string
-			name string
-		}
-		if err := rows.Scan(&feature.id, &feature.name); err != nil {
-			log.Println("Error scanning row:", err)
+	// features start disabled unless their state, value and targeting are
+	// copied from a source environment
+	if createEnvironmentRequest.SourceEnvironmentID != "" {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE features
+			SET (enabled, json_value) = (
+				SELECT s.enabled, s.json_value
+				FROM features s
+				WHERE s.id = features.id AND s.project_id = features.project_id AND s.environment_id = ? AND s.is_deleted = 0
+			)
+			WHERE project_id = ? AND environment_id = ? AND EXISTS (
+				SELECT 1
+				FROM features s
+				WHERE s.id = features.id AND s.project_id = features.project_id AND s.environment_id = ? AND s.is_deleted = 0
+			)
+		`, createEnvironmentRequest.SourceEnvironmentID, projectID, environmentID, createEnvironmentRequest.SourceEnvironmentID)
+		if err != nil {
+			log.Println("Error copying features from source environment:", err)
 			return "", err
 		}
-		features = append(features, feature)
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO environments 
-		(id, name, project_id) 
-		VALUES 
-		(?, ?, ?)
-	`, sdkKey, createEnvironmentRequest.Name, projectID)
+	return environmentID, nil
+}
+
+// insertEnvironment adds an environment with its sdk keys, the features the
+// project already has start disabled in it
+func insertEnvironment(ctx context.Context, tx *LoggerTx, projectID, name string, requiredRole *string, requiresApproval bool) (string, error) {
+	newEnvironmentID, _ := uuid.NewRandom()
+	environmentID := fmt.Sprintf("env-%s", newEnvironmentID.String())
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO environments
+		(id, name, project_id, required_role, requires_approval)
+		VALUES
+		(?, ?, ?, ?, ?)
+	`, environmentID, name, projectID, requiredRole, requiresApproval)
 	if err != nil {
 		log.Println("Error inserting environment:", err)
 		return "", err
 	}
 
-	for _, feature := range features {
-		_, err = tx.ExecContext(ctx, `
-			INSERT INTO features 
-			(id, name, enabled, json_value, environment_id, project_id) 
-			VALUES (?, ?, ?, ?, ?, ?)
-		`, feature.id, feature.name, false, nil, sdkKey, projectID)
-		if err != nil {
-			log.Println("Error inserting feature for new environment:", err)
+	for _, kind := range []string{models.SDKKeyKindServer, models.SDKKeyKindClient} {
+		if _, _, err = insertSDKKey(ctx, tx, projectID, environmentID, kind); err != nil {
+			log.Println("Error inserting sdk key for new environment:", err)
 			return "", err
 		}
 	}
 
-	return sdkKey, nil
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO features
+		(id, name, label, description, enabled, json_value, environment_id, project_id, client_side_available, owner, maintainer, type, properties, links, managed)
+		SELECT f.id, MAX(f.name), MAX(f.label), MAX(f.description), 0, NULL, ?, f.project_id, MAX(f.client_side_available), MAX(f.owner), MAX(f.maintainer), MAX(f.type), MAX(f.properties), MAX(f.links), MAX(f.managed)
+		FROM features f
+		WHERE f.project_id = ? AND f.is_deleted = 0
+		GROUP BY f.id
+	`, environmentID, projectID)
+	if err != nil {
+		log.Println("Error inserting features for new environment:", err)
+		return "", err
+	}
+	return environmentID, nil
 }
 
 func (db *DB) GetEnvironments(ctx context.Context, projectID string) ([]*models.Environment, error) {
@@ -94,7 +110,7 @@ func (db *DB) GetEnvironments(ctx context.Context, projectID string) ([]*models.
 	}()
 
 	rows, err := db.QueryContext(ctx, `
-		SELECT id, name 
+		SELECT id, name, required_role, requires_approval 
 		FROM environments 
 		WHERE project_id = ? and is_deleted = 0
 	`, projectID)
@@ -108,16 +124,15 @@ func (db *DB) GetEnvironments(ctx context.Context, projectID string) ([]*models.
 
 	for rows.Next() {
 		var id, name string
+		var requiredRole *string
+		var requiresApproval bool
 
-		if err := rows.Scan(&id, &name); err != nil {
+		if err := rows.Scan(&id, &name, &requiredRole, &requiresApproval); err != nil {
 			log.Println("Error scanning row:", err)
 			return nil, err
 		}
 
-		environments = append(environments, &models.Environment{
-			ID:   id,
-			Name: name,
-		})
+		environments = append(environments, newEnvironment(id, name, requiredRole, requiresApproval))
 	}
 
 	return environments, nil
@@ -134,10 +149,12 @@ func (db *DB) GetEnvironment(ctx context.Context, projectID, environmentID strin
 	}()
 
 	var id, name string
+	var requiredRole *string
+	var requiresApproval bool
 	rows, err := tx.QueryContext(ctx, `
-		SELECT e.id, e.name 
+		SELECT e.id, e.name, e.required_role, e.requires_approval 
 		FROM environments e 
-		WHERE e.id = ? AND e.project_id = ?
+		WHERE e.id = ? AND e.project_id = ? AND e.is_deleted = 0
 	`, environmentID, projectID)
 	if err != nil {
 		log.Println("Error querying environment from database:", err)
@@ -145,21 +162,15 @@ func (db *DB) GetEnvironment(ctx context.Context, projectID, environmentID strin
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		if err := rows.Scan(&id, &name); err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				log.Println("No rows found")
-				return nil, ErrNoRows
-			}
-			log.Println("Error scanning row:", err)
-			return nil, err
-		}
+	if !rows.Next() {
+		return nil, &NotFoundError{Resource: "environment", ID: environmentID}
+	}
+	if err := rows.Scan(&id, &name, &requiredRole, &requiresApproval); err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
 	}
 
-	return &models.Environment{
-		ID:   id,
-		Name: name,
-	}, nil
+	return newEnvironment(id, name, requiredRole, requiresApproval), nil
 }
 
 func (db *DB) UpdateEnvironment(ctx context.Context, projectID, environmentID string, updateEnvironmentRequest *models.UpdateEnvironmentRequest) error {
@@ -172,18 +183,62 @@ func (db *DB) UpdateEnvironment(ctx context.Context, projectID, environmentID st
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	_, err = tx.ExecContext(ctx, `
+	result, err := tx.ExecContext(ctx, `
 		UPDATE environments 
 		SET name = ?, updated_at = CURRENT_TIMESTAMP 
-		WHERE id = ? AND project_id = ?
+		WHERE id = ? AND project_id = ? AND is_deleted = 0
 	`, updateEnvironmentRequest.Name, environmentID, projectID)
 	if err != nil {
 		log.Println("Error updating environment in database:", err)
 		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "environment", ID: environmentID}
+	}
+
+	if updateEnvironmentRequest.RequiredRole != nil {
+		var requiredRole *string
+		if *updateEnvironmentRequest.RequiredRole != "" {
+			requiredRole = updateEnvironmentRequest.RequiredRole
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE environments 
+			SET required_role = ? 
+			WHERE id = ? AND project_id = ?
+		`, requiredRole, environmentID, projectID)
+		if err != nil {
+			log.Println("Error updating environment restriction in database:", err)
+			return err
+		}
+	}
+
+	if updateEnvironmentRequest.RequiresApproval != nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE environments 
+			SET requires_approval = ? 
+			WHERE id = ? AND project_id = ?
+		`, *updateEnvironmentRequest.RequiresApproval, environmentID, projectID)
+		if err != nil {
+			log.Println("Error updating environment approval setting in database:", err)
+			return err
+		}
+	}
 	return nil
 }
 
+func newEnvironment(id, name string, requiredRole *string, requiresApproval bool) *models.Environment {
+	environment := &models.Environment{
+		ID:               id,
+		Name:             name,
+		RequiresApproval: requiresApproval,
+	}
+	if requiredRole != nil {
+		environment.Restricted = true
+		environment.RequiredRole = *requiredRole
+	}
+	return environment
+}
+
 func (db *DB) DeleteEnvironment(ctx context.Context, projectID, environmentID string) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -194,47 +249,86 @@ func (db *DB) DeleteEnvironment(ctx context.Context, projectID, environmentID st
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	rows, err := tx.QueryContext(ctx, `
-		SELECT id FROM features 
-		WHERE environment_id = ? AND project_id = ? AND is_deleted = 0
+	result, err := tx.ExecContext(ctx, `
+		UPDATE environments
+		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND project_id = ? AND is_deleted = 0
 	`, environmentID, projectID)
 	if err != nil {
-		log.Println("Error querying features from database:", err)
+		log.Println("Error deleting environment in database:", err)
 		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "environment", ID: environmentID}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE features
+		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP, deleted_with = ?
+		WHERE environment_id = ? AND project_id = ? AND is_deleted = 0
+	`, environmentID, environmentID, projectID)
+	if err != nil {
+		log.Println("Error deleting features in database:", err)
+		return err
+	}
+
+	return nil
+}
+
+// CompareEnvironments reports for every feature of the project whether its
+// state, value or targeting differs between two environments
+func (db *DB) CompareEnvironments(ctx context.Context, projectID, baseEnvironmentID, targetEnvironmentID string) (*models.EnvironmentComparison, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT b.id, b.name, b.label, b.enabled, b.json_value, t.enabled, t.json_value
+		FROM features b
+		INNER JOIN features t ON t.id = b.id AND t.project_id = b.project_id AND t.environment_id = ? AND t.is_deleted = 0
+		WHERE b.project_id = ? AND b.environment_id = ? AND b.is_deleted = 0
+		ORDER BY b.name, b.id
+	`, targetEnvironmentID, projectID, baseEnvironmentID)
+	if err != nil {
+		log.Println("Error querying features from database:", err)
+		return nil, err
+	}
 	defer rows.Close()
 
-	var featureIDs []string
+	comparison := &models.EnvironmentComparison{
+		BaseEnvironmentID:   baseEnvironmentID,
+		TargetEnvironmentID: targetEnvironmentID,
+		Features:            make([]*models.FeatureComparison, 0),
+	}
 	for rows.Next() {
-		var featureID string
-		if err := rows.Scan(&featureID); err != nil {
+		var feature models.FeatureComparison
+		var baseValue, targetValue []byte
+		if err := rows.Scan(&feature.FeatureID, &feature.Name, &feature.Label, &feature.Base.Enabled, &baseValue, &feature.Target.Enabled, &targetValue); err != nil {
 			log.Println("Error scanning row:", err)
-			return err
+			return nil, err
 		}
-		featureIDs = append(featureIDs, featureID)
-	}
+		json.Unmarshal(baseValue, &feature.Base.JsonValue)
+		json.Unmarshal(targetValue, &feature.Target.JsonValue)
 
-	for _, featureID := range featureIDs {
-		_, err := tx.ExecContext(ctx, `
-			UPDATE features
-			SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
-			WHERE feature_id = ? AND environment_id = ? AND project_id = ?
-		`, featureID, environmentID, projectID)
-		if err != nil {
-			log.Println("Error deleting features in database:", err)
-			return err
+		base, target := feature.Base.JsonValue, feature.Target.JsonValue
+		feature.StateDiffers = feature.Base.Enabled != feature.Target.Enabled
+		feature.ValueDiffers = !slices.Equal(base.Values, target.Values)
+		feature.TargetingDiffers = base.Key != target.Key || base.Enabled != target.Enabled
+		feature.Differs = feature.StateDiffers || feature.ValueDiffers || feature.TargetingDiffers
+		if feature.Differs {
+			comparison.Different++
 		}
+		comparison.Features = append(comparison.Features, &feature)
 	}
-
-	_, err = tx.ExecContext(ctx, `
-		UPDATE environments
-		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
-		WHERE id = ? AND project_id = ?
-	`, environmentID, projectID)
-	if err != nil {
-		log.Println("Error deleting environment in database:", err)
-		return err
+	if err := rows.Err(); err != nil {
+		log.Println("Error iterating rows:", err)
+		return nil, err
 	}
 
-	return nil
+	return comparison, nil
 }
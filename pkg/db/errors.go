@@ -0,0 +1,94 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"modulyn/pkg/models"
+	"strings"
+)
+
+var (
+	ErrNoRows             = errors.New("no results found")
+	ErrConflict           = errors.New("conflicting change")
+	ErrNotPending         = errors.New("change request is not pending")
+	ErrValidation         = errors.New("validation failed")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// NotFoundError reports a missing resource, it matches ErrNoRows
+type NotFoundError struct {
+	Resource string
+	ID       string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ID == "" {
+		return e.Resource + " not found"
+	}
+	return fmt.Sprintf("%s %s not found", e.Resource, e.ID)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNoRows
+}
+
+// ConflictError reports a change that conflicts with the current state,
+// it matches ErrConflict
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// ValidationError lists every invalid field of a request, it matches
+// ErrValidation
+type ValidationError struct {
+	Fields []models.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, models.FieldError{Field: field, Message: message})
+}
+
+// Err returns the error when a field was recorded and nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// PreconditionFailedError reports a conditional request whose condition
+// does not hold, Current is the state the caller should retry against. It
+// matches ErrPreconditionFailed.
+type PreconditionFailedError struct {
+	Message string
+	Current any
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return e.Message
+}
+
+func (e *PreconditionFailedError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
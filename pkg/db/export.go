@@ -0,0 +1,416 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"modulyn/pkg/models"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ExportDB interface {
+	ExportProject(ctx context.Context, projectID string) (*models.ProjectExport, error)
+	ImportProject(ctx context.Context, projectID string, document *models.ProjectExport, options *models.ImportOptions, author *models.Principal) (*models.ImportReport, error)
+}
+
+// ExportProject copies the project's active environments and features into
+// an export document
+func (db *DB) ExportProject(ctx context.Context, projectID string) (*models.ProjectExport, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	document := &models.ProjectExport{
+		Version:      models.ProjectExportVersion,
+		ExportedAt:   time.Now().UTC().Format(time.RFC3339),
+		Environments: make([]*models.ExportedEnvironment, 0),
+		Features:     make([]*models.ExportedFeature, 0),
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, name FROM projects WHERE id = ? AND is_deleted = 0
+	`, projectID).Scan(&document.Project.ID, &document.Project.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &NotFoundError{Resource: "project", ID: projectID}
+		return nil, err
+	}
+	if err != nil {
+		log.Println("Error querying project from database:", err)
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, required_role, requires_approval
+		FROM environments
+		WHERE project_id = ? AND is_deleted = 0
+		ORDER BY name, id
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying environments from database:", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var environment models.ExportedEnvironment
+		var requiredRole *string
+		if err = rows.Scan(&environment.ID, &environment.Name, &requiredRole, &environment.RequiresApproval); err != nil {
+			rows.Close()
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		if requiredRole != nil {
+			environment.RequiredRole = *requiredRole
+		}
+		document.Environments = append(document.Environments, &environment)
+	}
+	rows.Close()
+
+	rows, err = tx.QueryContext(ctx, selectFeaturesSQL+`
+		WHERE f.project_id = ? AND f.is_deleted = 0
+		ORDER BY f.name, f.id, e.name
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying features from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	features, err := scanFeatures(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make(map[string]*models.ExportedFeature)
+	for _, feature := range features {
+		exportedFeature, ok := exported[feature.ID]
+		if !ok {
+			exportedFeature = &models.ExportedFeature{
+				ID:                  feature.ID,
+				Key:                 feature.Label,
+				Name:                feature.Name,
+				Description:         feature.Description,
+				ClientSideAvailable: feature.ClientSideAvailable,
+				Tags:                feature.Tags,
+				Owner:               feature.Owner,
+				Maintainer:          feature.Maintainer,
+				Type:                feature.Type,
+				Properties:          feature.Properties,
+				Links:               feature.Links,
+				States:              make(map[string]models.FeatureState),
+			}
+			exported[feature.ID] = exportedFeature
+			document.Features = append(document.Features, exportedFeature)
+		}
+		exportedFeature.States[feature.EnvironmentID] = models.FeatureState{
+			Enabled:   feature.Enabled,
+			JsonValue: feature.JsonValue,
+		}
+	}
+
+	return document, nil
+}
+
+// ImportProject writes an export document into the project, or into a new
+// project when projectID is empty, in one transaction. States for
+// environments that required approval before the import become change
+// requests by the author.
+func (db *DB) ImportProject(ctx context.Context, projectID string, document *models.ProjectExport, options *models.ImportOptions, author *models.Principal) (*models.ImportReport, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	existingEnvironments := make(map[string]string)
+	existingFeatures := make(map[string]string)
+	approvalEnvironments := make(map[string]string)
+
+	if projectID == "" {
+		newID, _ := uuid.NewRandom()
+		projectID = newID.String()
+
+		name := options.Name
+		if name == "" {
+			name = document.Project.Name
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO projects
+			(id, name)
+			VALUES
+			(?, ?)
+		`, projectID, name)
+		if err != nil {
+			log.Println("Error inserting project in database:", err)
+			return nil, err
+		}
+	} else {
+		if err = requireActiveProject(ctx, tx, projectID); err != nil {
+			return nil, err
+		}
+
+		existingEnvironments, err = queryNameIDs(ctx, tx, `
+			SELECT name, id FROM environments WHERE project_id = ? AND is_deleted = 0
+		`, projectID)
+		if err != nil {
+			log.Println("Error querying environments from database:", err)
+			return nil, err
+		}
+
+		existingFeatures, err = queryNameIDs(ctx, tx, `
+			SELECT DISTINCT label, id FROM features WHERE project_id = ? AND is_deleted = 0
+		`, projectID)
+		if err != nil {
+			log.Println("Error querying features from database:", err)
+			return nil, err
+		}
+
+		approvalEnvironments, err = queryNameIDs(ctx, tx, `
+			SELECT id, name FROM environments WHERE project_id = ? AND is_deleted = 0 AND requires_approval = 1
+		`, projectID)
+		if err != nil {
+			log.Println("Error querying environments from database:", err)
+			return nil, err
+		}
+	}
+
+	if options.Strategy == models.ImportStrategyFail {
+		var conflicts []string
+		for _, feature := range document.Features {
+			if _, ok := existingFeatures[feature.Key]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("%q", feature.Key))
+			}
+		}
+		if len(conflicts) > 0 {
+			err = &ConflictError{Message: fmt.Sprintf("Feature keys %s are already used in this project", strings.Join(conflicts, ", "))}
+			return nil, err
+		}
+	}
+
+	report := &models.ImportReport{
+		ProjectID:    projectID,
+		Strategy:     options.Strategy,
+		Environments: make([]*models.ImportedResource, 0, len(document.Environments)),
+		Features:     make([]*models.ImportedResource, 0, len(document.Features)),
+	}
+
+	// environments in the document map to the environments of this
+	// installation, environments of the project missing from the document
+	// are left alone and get imported features disabled
+	environmentIDs := make(map[string]string, len(document.Environments))
+	for _, environment := range document.Environments {
+		var requiredRole *string
+		if environment.RequiredRole != "" {
+			requiredRole = &environment.RequiredRole
+		}
+
+		imported := &models.ImportedResource{
+			SourceID: environment.ID,
+			Name:     environment.Name,
+		}
+
+		if existingID, ok := existingEnvironments[environment.Name]; ok {
+			imported.ID = existingID
+			imported.Action = models.ImportActionSkipped
+			if options.Strategy == models.ImportStrategyOverwrite {
+				_, err = tx.ExecContext(ctx, `
+					UPDATE environments
+					SET required_role = ?, requires_approval = ?, updated_at = CURRENT_TIMESTAMP
+					WHERE id = ? AND project_id = ?
+				`, requiredRole, environment.RequiresApproval, existingID, projectID)
+				if err != nil {
+					log.Println("Error updating environment in database:", err)
+					return nil, err
+				}
+				imported.Action = models.ImportActionUpdated
+			}
+		} else {
+			imported.Action = models.ImportActionCreated
+			imported.ID, err = insertEnvironment(ctx, tx, projectID, environment.Name, requiredRole, environment.RequiresApproval)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		environmentIDs[environment.ID] = imported.ID
+		report.Environments = append(report.Environments, imported)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM environments WHERE project_id = ? AND is_deleted = 0
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying environments from database:", err)
+		return nil, err
+	}
+	var projectEnvironmentIDs []string
+	for rows.Next() {
+		var environmentID string
+		if err = rows.Scan(&environmentID); err != nil {
+			rows.Close()
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		projectEnvironmentIDs = append(projectEnvironmentIDs, environmentID)
+	}
+	rows.Close()
+
+	for _, feature := range document.Features {
+		states := make(map[string]models.FeatureState, len(feature.States))
+		for sourceEnvironmentID, state := range feature.States {
+			states[environmentIDs[sourceEnvironmentID]] = state
+		}
+
+		var links []byte
+		if feature.Links != nil {
+			links, _ = json.Marshal(feature.Links)
+		}
+
+		imported := &models.ImportedResource{
+			SourceID: feature.ID,
+			Name:     feature.Name,
+		}
+
+		if existingID, ok := existingFeatures[feature.Key]; ok {
+			imported.ID = existingID
+			imported.Action = models.ImportActionSkipped
+			report.Features = append(report.Features, imported)
+			if options.Strategy != models.ImportStrategyOverwrite {
+				continue
+			}
+			imported.Action = models.ImportActionUpdated
+
+			if err = rejectManagedFeature(ctx, tx, projectID, existingID); err != nil {
+				return nil, err
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				UPDATE features
+				SET name = ?, description = ?, client_side_available = ?, owner = ?, maintainer = ?, type = ?, properties = ?, links = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND project_id = ? AND is_deleted = 0
+			`, feature.Name, feature.Description, feature.ClientSideAvailable, feature.Owner, feature.Maintainer, cmp.Or(feature.Type, models.FeatureTypeRelease), encodeProperties(feature.Properties), links, existingID, projectID)
+			if err != nil {
+				log.Println("Error updating feature in database:", err)
+				return nil, err
+			}
+
+			for environmentID, state := range states {
+				if _, ok := approvalEnvironments[environmentID]; ok {
+					continue
+				}
+				jsonValueBytes, _ := json.Marshal(state.JsonValue)
+				_, err = tx.ExecContext(ctx, `
+					UPDATE features
+					SET enabled = ?, json_value = ?
+					WHERE id = ? AND environment_id = ? AND project_id = ? AND is_deleted = 0
+				`, state.Enabled, jsonValueBytes, existingID, environmentID, projectID)
+				if err != nil {
+					log.Println("Error updating feature in database:", err)
+					return nil, err
+				}
+			}
+		} else {
+			newID, _ := uuid.NewRandom()
+			imported.ID = newID.String()
+			imported.Action = models.ImportActionCreated
+			report.Features = append(report.Features, imported)
+
+			for _, environmentID := range projectEnvironmentIDs {
+				var enabled bool
+				var jsonValueBytes []byte
+				// the feature starts disabled in environments that require
+				// approval, its imported state is proposed below
+				_, requiresApproval := approvalEnvironments[environmentID]
+				if state, ok := states[environmentID]; ok && !requiresApproval {
+					enabled = state.Enabled
+					jsonValueBytes, _ = json.Marshal(state.JsonValue)
+				}
+
+				_, err = tx.ExecContext(ctx, `
+					INSERT INTO features
+					(id, name, label, description, enabled, json_value, environment_id, project_id, client_side_available, owner, maintainer, type, properties, links, managed)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)
+				`, imported.ID, feature.Name, feature.Key, feature.Description, enabled, jsonValueBytes, environmentID, projectID, feature.ClientSideAvailable, feature.Owner, feature.Maintainer, cmp.Or(feature.Type, models.FeatureTypeRelease), encodeProperties(feature.Properties), links)
+				if err != nil {
+					log.Println("Error inserting feature in database:", err)
+					return nil, err
+				}
+			}
+		}
+
+		if err = setFeatureTags(ctx, tx, projectID, imported.ID, feature.Tags); err != nil {
+			log.Println("Error setting feature tags:", err)
+			return nil, err
+		}
+
+		for _, environmentID := range slices.Sorted(maps.Keys(states)) {
+			if _, ok := approvalEnvironments[environmentID]; !ok {
+				continue
+			}
+			var changeRequest *models.ChangeRequest
+			changeRequest, err = proposeImportedState(ctx, tx, projectID, imported.ID, environmentID, states[environmentID], author)
+			if err != nil {
+				return nil, err
+			}
+			if changeRequest != nil {
+				report.ChangeRequests = append(report.ChangeRequests, changeRequest)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// proposeImportedState records a change request for the imported state of
+// a feature in an environment that requires approval, nothing when the
+// feature already has that state there
+func proposeImportedState(ctx context.Context, tx *LoggerTx, projectID, featureID, environmentID string, state models.FeatureState, author *models.Principal) (*models.ChangeRequest, error) {
+	current, err := currentFeatureState(ctx, tx, projectID, featureID, environmentID)
+	if err != nil {
+		return nil, err
+	}
+	if sameFeatureState(current, &state) {
+		return nil, nil
+	}
+
+	return insertChangeRequest(ctx, tx, projectID, featureID, &models.UpdateFeatureRequest{
+		EnvironmentID: environmentID,
+		Enabled:       state.Enabled,
+		JsonValue:     state.JsonValue,
+		Reason:        "Imported from a project export",
+	}, author)
+}
+
+// queryNameIDs maps the first column of the rows to the second
+func queryNameIDs(ctx context.Context, tx *LoggerTx, query string, args ...any) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string)
+	for rows.Next() {
+		var name, id string
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+	return ids, rows.Err()
+}
@@ -1,21 +1,29 @@
 package db
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"modulyn/pkg/models"
+	"slices"
+	"strings"
 	"time"
 )
 
 type FeatureDB interface {
 	CreateFeature(ctx context.Context, featureID, projectID string, environments []*models.Environment, createFeatureRequest *models.CreateFeatureRequest) error
-	GetFeatures(ctx context.Context, projectID, searchTerm string) ([]*models.Feature, error)
+	GetFeatures(ctx context.Context, projectID string, filter *models.FeatureFilter) ([]*models.Feature, string, error)
 	GetFeaturesByID(ctx context.Context, projectID, featureID string) ([]*models.Feature, error)
-	UpdateFeatures(ctx context.Context, projectID, featureID string, updateFeaturesRequest []*models.UpdateFeatureRequest) error
-	DeleteFeature(ctx context.Context, projectID, featureID string) error
+	GetFeaturesByKey(ctx context.Context, projectID, key string) ([]*models.Feature, error)
+	UpdateFeatures(ctx context.Context, projectID, featureID string, updateFeaturesRequest, proposals []*models.UpdateFeatureRequest, author *models.Principal) ([]*models.ChangeRequest, error)
+	UpdateFeatureClientSide(ctx context.Context, projectID, featureID string, clientSideAvailable bool) error
+	UpdateFeatureMetadata(ctx context.Context, projectID, featureID string, updateFeatureMetadataRequest *models.UpdateFeatureMetadataRequest) error
+	DeleteFeature(ctx context.Context, projectID, featureID, etag string) error
 	GetFeaturesByEnvironmentID(ctx context.Context, environmentID string) ([]*models.Feature, error)
 }
 
@@ -29,103 +37,164 @@ func (db *DB) CreateFeature(ctx context.Context, featureID, projectID string, en
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	featureLabel := transformLabel(createFeatureRequest.Name)
+	featureLabel := createFeatureRequest.Key
+	if featureLabel == "" {
+		featureLabel = transformLabel(createFeatureRequest.Name)
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM features WHERE project_id = ? AND label = ? AND is_deleted = 0)
+	`, projectID, featureLabel).Scan(&exists)
+	if err != nil {
+		log.Println("Error checking feature key:", err)
+		return err
+	}
+	if exists {
+		err = &ConflictError{Message: fmt.Sprintf("Feature key %q is already used in this project", featureLabel)}
+		return err
+	}
+
+	var links []byte
+	if createFeatureRequest.Links != nil {
+		links, _ = json.Marshal(createFeatureRequest.Links)
+	}
 
 	for _, environment := range environments {
-		_, err := tx.ExecContext(ctx, `
+		_, err = tx.ExecContext(ctx, `
 			INSERT INTO features 
-			(id, name, label, description, enabled, json_value, environment_id, project_id)
+			(id, name, label, description, enabled, json_value, environment_id, project_id, client_side_available, owner, maintainer, type, properties, links)
 			VALUES 
-			(?, ?, ?, ?, ?, ?, ?, ?)
-		`, featureID, createFeatureRequest.Name, featureLabel, createFeatureRequest.Description, false, nil, environment.ID, projectID)
+			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, featureID, createFeatureRequest.Name, featureLabel, createFeatureRequest.Description, false, nil, environment.ID, projectID, createFeatureRequest.ClientSideAvailable,
+			createFeatureRequest.Owner, createFeatureRequest.Maintainer, cmp.Or(createFeatureRequest.Type, models.FeatureTypeRelease), encodeProperties(createFeatureRequest.Properties), links)
+		if isUniqueConstraintError(err) {
+			err = &ConflictError{Message: fmt.Sprintf("Feature key %q is already used in this project", featureLabel)}
+			return err
+		}
 		if err != nil {
 			log.Println("Error inserting feature in database:", err)
 			return err
 		}
 	}
 
+	if err = setFeatureTags(ctx, tx, projectID, featureID, createFeatureRequest.Tags); err != nil {
+		log.Println("Error setting feature tags:", err)
+		return err
+	}
+
 	return nil
 }
 
-func (db *DB) GetFeatures(ctx context.Context, projectID, searchTerm string) ([]*models.Feature, error) {
+var featureSortColumns = map[string]sortColumn{
+	models.SortName:    textSortColumn("f.name"),
+	models.SortCreated: timeSortColumn("f.created_at"),
+	models.SortUpdated: timeSortColumn("f.updated_at"),
+}
+
+// GetFeatures returns one page of the features of a project, one row per
+// feature per environment, and the cursor of the next page
+func (db *DB) GetFeatures(ctx context.Context, projectID string, filter *models.FeatureFilter) ([]*models.Feature, string, error) {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("Error starting transaction:", err)
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	var rows *sql.Rows
-
-	if searchTerm != "" {
-		rows, err = tx.QueryContext(ctx, `
-			SELECT f.id, f.name, f.label, f.description, f.enabled, f.json_value, f.created_at, f.updated_at, f.deleted_at, f.environment_id, e.name, f.project_id, p.name
-			FROM features f
-			INNER JOIN environments e ON f.environment_id = e.id
-			INNER JOIN projects p ON f.project_id = p.id
-			WHERE f.project_id = ? AND f.is_deleted = 0 AND (f.name like ? OR f.label like ?)
-			ORDER BY f.name, e.name
-		`, projectID, fmt.Sprintf("%%%s%%", searchTerm), fmt.Sprintf("%%%s%%", searchTerm))
-	} else {
-		rows, err = tx.QueryContext(ctx, `
-			SELECT f.id, f.name, f.label, f.description, f.enabled, f.json_value, f.created_at, f.updated_at, f.deleted_at, f.environment_id, e.name, f.project_id, p.name
-			FROM features f
-			INNER JOIN environments e ON f.environment_id = e.id
-			INNER JOIN projects p ON f.project_id = p.id
-			WHERE f.project_id = ? AND f.is_deleted = 0
-			ORDER BY f.name, e.name
-		`, projectID)
+	query := selectFeaturesSQL + `
+		WHERE f.project_id = ? AND f.is_deleted = 0
+	`
+	args := []any{projectID}
+
+	if filter.Search != "" {
+		query += " AND (f.name like ? OR f.label like ?)"
+		args = append(args, fmt.Sprintf("%%%s%%", filter.Search), fmt.Sprintf("%%%s%%", filter.Search))
+	}
+	if filter.EnvironmentID != "" {
+		query += " AND f.environment_id = ?"
+		args = append(args, filter.EnvironmentID)
+	}
+	if filter.Owner != "" {
+		query += " AND f.owner = ?"
+		args = append(args, filter.Owner)
+	}
+	if filter.Maintainer != "" {
+		query += " AND f.maintainer = ?"
+		args = append(args, filter.Maintainer)
+	}
+	if filter.Type != "" {
+		query += " AND f.type = ?"
+		args = append(args, filter.Type)
+	}
+	for _, key := range slices.Sorted(maps.Keys(filter.Properties)) {
+		query += " AND json_extract(f.properties, ?) = ?"
+		args = append(args, fmt.Sprintf("$.%q", key), filter.Properties[key])
+	}
+	if filter.Enabled != nil {
+		query += " AND f.enabled = ?"
+		args = append(args, *filter.Enabled)
+	}
+	if filter.UpdatedSince != "" {
+		query += " AND datetime(f.updated_at) >= datetime(?)"
+		args = append(args, filter.UpdatedSince)
+	}
+	if len(filter.Tags) > 0 {
+		query += `
+		AND f.id IN (
+			SELECT feature_id FROM feature_tags
+			WHERE project_id = ? AND tag IN (?` + strings.Repeat(", ?", len(filter.Tags)-1) + `)`
+		args = append(args, projectID)
+		for _, tag := range filter.Tags {
+			args = append(args, tag)
+		}
+		if filter.TagMatch == models.TagMatchAll {
+			query += `
+			GROUP BY feature_id
+			HAVING COUNT(*) = ?`
+			args = append(args, len(filter.Tags))
+		}
+		query += `
+		)`
+	}
+
+	where, keysetArgs, orderBy, err := keyset(filter.ListOptions, featureSortColumns, []string{"f.id", "f.environment_id"})
+	if err != nil {
+		return nil, "", err
 	}
+
+	rows, err := tx.QueryContext(ctx, query+where+"\n"+orderBy, append(args, keysetArgs...)...)
 	if err != nil {
 		log.Println("Error querying features from database:", err)
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	features := make([]*models.Feature, 0)
-
-	for rows.Next() {
-		var id, name, label, environmentID, projectID, environmentName, projectName string
-		var description *string
-		var enabled int
-		var jsonValue []byte
-		var createdAt, updatedAt time.Time
-		var deletedAt *time.Time
-
-		if err := rows.Scan(&id, &name, &label, &description, &enabled, &jsonValue, &createdAt, &updatedAt, &deletedAt, &environmentID, &environmentName, &projectID, &projectName); err != nil {
-			log.Println("Error scanning row:", err)
-			return nil, err
-		}
+	features, err := scanFeatures(rows)
+	if err != nil {
+		return nil, "", err
+	}
 
-		var jsonVal models.JsonValue
-		json.Unmarshal(jsonValue, &jsonVal)
+	if filter.Limit <= 0 || len(features) <= filter.Limit {
+		return features, "", nil
+	}
 
-		feature := &models.Feature{
-			ID:              id,
-			Name:            name,
-			Label:           label,
-			Enabled:         enabled == 1,
-			JsonValue:       jsonVal,
-			CreatedAt:       createdAt.Format(time.RFC3339),
-			UpdatedAt:       updatedAt.Format(time.RFC3339),
-			EnvironmentID:   environmentID,
-			EnvironmentName: environmentName,
-			ProjectID:       projectID,
-			ProjectName:     projectName,
-		}
-		if deletedAt != nil {
-			feature.DeletedAt = deletedAt.Format(time.RFC3339)
-		}
-		if description != nil {
-			feature.Description = *description
-		}
+	features = features[:filter.Limit]
+	last := features[len(features)-1]
+	return features, encodeCursor(featureSortValue(last, filter.Sort), last.ID, last.EnvironmentID), nil
+}
 
-		features = append(features, feature)
+func featureSortValue(feature *models.Feature, sort string) string {
+	switch sort {
+	case models.SortCreated:
+		return feature.CreatedAt
+	case models.SortUpdated:
+		return feature.UpdatedAt
+	default:
+		return feature.Name
 	}
-
-	return features, nil
 }
 
 func (db *DB) GetFeaturesByEnvironmentID(ctx context.Context, environmentID string) ([]*models.Feature, error) {
@@ -139,11 +208,7 @@ func (db *DB) GetFeaturesByEnvironmentID(ctx context.Context, environmentID stri
 	}()
 
 	// Query the database for flags associated with the given SDK key
-	rows, err := tx.QueryContext(ctx, `
-		SELECT f.id, f.name, f.label, f.description, f.enabled, f.json_value, f.created_at, f.updated_at, f.deleted_at, f.environment_id, e.name, f.project_id, p.name
-		FROM features f
-		INNER JOIN environments e ON f.environment_id = e.id
-		INNER JOIN projects p ON f.project_id = p.id
+	rows, err := tx.QueryContext(ctx, selectFeaturesSQL+`
 		WHERE f.environment_id = ? AND f.is_deleted = 0
 		ORDER BY f.name, e.name
 	`, environmentID)
@@ -153,51 +218,41 @@ func (db *DB) GetFeaturesByEnvironmentID(ctx context.Context, environmentID stri
 	}
 	defer rows.Close()
 
-	features := make([]*models.Feature, 0)
-
-	for rows.Next() {
-		var id, name, label, environmentID, projectID, environmentName, projectName string
-		var description *string
-		var enabled int
-		var jsonValue []byte
-		var createdAt, updatedAt time.Time
-		var deletedAt *time.Time
-
-		if err := rows.Scan(&id, &name, &label, &description, &enabled, &jsonValue, &createdAt, &updatedAt, &deletedAt, &environmentID, &environmentName, &projectID, &projectName); err != nil {
-			log.Println("Error scanning row:", err)
-			return nil, err
-		}
+	return scanFeatures(rows)
+}
 
-		var jsonVal models.JsonValue
-		json.Unmarshal(jsonValue, &jsonVal)
+func (db *DB) GetFeaturesByID(ctx context.Context, projectID, featureID string) ([]*models.Feature, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
 
-		feature := &models.Feature{
-			ID:              id,
-			Name:            name,
-			Label:           label,
-			Enabled:         enabled == 1,
-			JsonValue:       jsonVal,
-			CreatedAt:       createdAt.Format(time.RFC3339),
-			UpdatedAt:       updatedAt.Format(time.RFC3339),
-			EnvironmentID:   environmentID,
-			EnvironmentName: environmentName,
-			ProjectID:       projectID,
-			ProjectName:     projectName,
-		}
-		if deletedAt != nil {
-			feature.DeletedAt = deletedAt.Format(time.RFC3339)
-		}
-		if description != nil {
-			feature.Description = *description
-		}
+	features, err := queryFeatureRows(ctx, tx, projectID, featureID)
+	return features, err
+}
 
-		features = append(features, feature)
+// queryFeatureRows returns the active rows of a feature, one per environment
+func queryFeatureRows(ctx context.Context, tx *LoggerTx, projectID, featureID string) ([]*models.Feature, error) {
+	rows, err := tx.QueryContext(ctx, selectFeaturesSQL+`
+		WHERE f.project_id = ? AND f.id = ? AND f.is_deleted = 0
+		ORDER BY f.name, e.name
+	`, projectID, featureID)
+	if err != nil {
+		log.Println("Error querying features from database:", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	return features, nil
+	return scanFeatures(rows)
 }
 
-func (db *DB) GetFeaturesByID(ctx context.Context, projectID, featureID string) ([]*models.Feature, error) {
+// GetFeaturesByKey returns the feature with the given key in every
+// environment of the project
+func (db *DB) GetFeaturesByKey(ctx context.Context, projectID, key string) ([]*models.Feature, error) {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("Error starting transaction:", err)
@@ -207,66 +262,95 @@ func (db *DB) GetFeaturesByID(ctx context.Context, projectID, featureID string)
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	// Query the database for flags associated with the given SDK key
-	rows, err := tx.QueryContext(ctx, `
-		SELECT f.id, f.name, f.label, f.description, f.enabled, f.json_value, f.created_at, f.updated_at, f.deleted_at, f.environment_id, e.name, f.project_id, p.name
-		FROM features f
-		INNER JOIN environments e ON f.environment_id = e.id
-		INNER JOIN projects p ON f.project_id = p.id
-		WHERE f.project_id = ? AND f.id = ? AND f.is_deleted = 0
-		ORDER BY f.name, e.name
-	`, projectID, featureID)
+	rows, err := tx.QueryContext(ctx, selectFeaturesSQL+`
+		WHERE f.project_id = ? AND f.label = ? AND f.is_deleted = 0
+		ORDER BY e.name
+	`, projectID, key)
 	if err != nil {
 		log.Println("Error querying features from database:", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	features := make([]*models.Feature, 0)
+	return scanFeatures(rows)
+}
 
-	for rows.Next() {
-		var id, name, label, environmentID, projectID, environmentName, projectName string
-		var description *string
-		var enabled int
-		var jsonValue []byte
-		var createdAt, updatedAt time.Time
-		var deletedAt *time.Time
+// UpdateFeatures sets the state of a feature in the given environments and
+// records a pending change request for each of the proposals, in one
+// transaction so a failed update leaves no change request behind.
+// Updates carrying a version only apply while the row still has it,
+// otherwise a PreconditionFailedError holds the current rows.
+func (db *DB) UpdateFeatures(ctx context.Context, projectID, featureID string, updateFeaturesRequest, proposals []*models.UpdateFeatureRequest, author *models.Principal) ([]*models.ChangeRequest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
 
-		if err := rows.Scan(&id, &name, &label, &description, &enabled, &jsonValue, &createdAt, &updatedAt, &deletedAt, &environmentID, &environmentName, &projectID, &projectName); err != nil {
-			log.Println("Error scanning row:", err)
-			return nil, err
+	if err = rejectManagedFeature(ctx, tx, projectID, featureID); err != nil {
+		return nil, err
+	}
+
+	for _, updateFeatureRequest := range updateFeaturesRequest {
+		jsonValueBytes, _ := json.Marshal(updateFeatureRequest.JsonValue)
+		query := `
+			UPDATE features
+			SET enabled = ?, json_value = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND environment_id = ? AND project_id = ? AND is_deleted = 0
+		`
+		args := []any{updateFeatureRequest.Enabled, jsonValueBytes, featureID, updateFeatureRequest.EnvironmentID, projectID}
+		if updateFeatureRequest.Version != nil {
+			query += " AND version = ?"
+			args = append(args, *updateFeatureRequest.Version)
 		}
 
-		var jsonVal models.JsonValue
-		json.Unmarshal(jsonValue, &jsonVal)
+		var result sql.Result
+		result, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			log.Println("Error updating feature in database:", err)
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			continue
+		}
 
-		feature := &models.Feature{
-			ID:              id,
-			Name:            name,
-			Label:           label,
-			Enabled:         enabled == 1,
-			JsonValue:       jsonVal,
-			CreatedAt:       createdAt.Format(time.RFC3339),
-			UpdatedAt:       updatedAt.Format(time.RFC3339),
-			EnvironmentID:   environmentID,
-			EnvironmentName: environmentName,
-			ProjectID:       projectID,
-			ProjectName:     projectName,
+		if _, err = currentFeatureState(ctx, tx, projectID, featureID, updateFeatureRequest.EnvironmentID); errors.Is(err, ErrNoRows) {
+			err = &NotFoundError{Resource: "feature", ID: featureID + " in environment " + updateFeatureRequest.EnvironmentID}
+			return nil, err
 		}
-		if deletedAt != nil {
-			feature.DeletedAt = deletedAt.Format(time.RFC3339)
+		if err != nil {
+			return nil, err
 		}
-		if description != nil {
-			feature.Description = *description
+		var current []*models.Feature
+		if current, err = queryFeatureRows(ctx, tx, projectID, featureID); err != nil {
+			return nil, err
 		}
+		err = &PreconditionFailedError{
+			Message: fmt.Sprintf("Feature changed in environment %s since version %d", updateFeatureRequest.EnvironmentID, *updateFeatureRequest.Version),
+			Current: current,
+		}
+		return nil, err
+	}
 
-		features = append(features, feature)
+	changeRequests := make([]*models.ChangeRequest, 0, len(proposals))
+	for _, proposal := range proposals {
+		var changeRequest *models.ChangeRequest
+		changeRequest, err = insertChangeRequest(ctx, tx, projectID, featureID, proposal, author)
+		if err != nil {
+			return nil, err
+		}
+		changeRequests = append(changeRequests, changeRequest)
 	}
 
-	return features, nil
+	return changeRequests, nil
 }
 
-func (db *DB) UpdateFeatures(ctx context.Context, projectID, featureID string, updateFeaturesRequest []*models.UpdateFeatureRequest) error {
+// UpdateFeatureClientSide marks the feature as available to client-side
+// SDKs in every environment
+func (db *DB) UpdateFeatureClientSide(ctx context.Context, projectID, featureID string, clientSideAvailable bool) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("Error starting transaction:", err)
@@ -276,15 +360,64 @@ func (db *DB) UpdateFeatures(ctx context.Context, projectID, featureID string, u
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	for _, updateFeatureRequest := range updateFeaturesRequest {
-		jsonValueBytes, _ := json.Marshal(updateFeatureRequest.JsonValue)
-		_, err = tx.ExecContext(ctx, `
-			UPDATE features
-			SET enabled = ?, json_value = ?, updated_at = CURRENT_TIMESTAMP
-			WHERE id = ? AND environment_id = ? AND project_id = ?
-		`, updateFeatureRequest.Enabled, jsonValueBytes, featureID, updateFeatureRequest.EnvironmentID, projectID)
-		if err != nil {
-			log.Println("Error updating feature in database:", err)
+	if err = rejectManagedFeature(ctx, tx, projectID, featureID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE features
+		SET client_side_available = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND project_id = ? AND is_deleted = 0
+	`, clientSideAvailable, featureID, projectID)
+	if err != nil {
+		log.Println("Error updating feature in database:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "feature", ID: featureID}
+	}
+	return nil
+}
+
+// UpdateFeatureMetadata changes the fields a feature shares across its
+// environments on every environment row and replaces its tags
+func (db *DB) UpdateFeatureMetadata(ctx context.Context, projectID, featureID string, updateFeatureMetadataRequest *models.UpdateFeatureMetadataRequest) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	if err = rejectManagedFeature(ctx, tx, projectID, featureID); err != nil {
+		return err
+	}
+
+	var links []byte
+	if updateFeatureMetadataRequest.Links != nil {
+		links, _ = json.Marshal(updateFeatureMetadataRequest.Links)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE features
+		SET name = COALESCE(?, name), description = COALESCE(?, description), owner = COALESCE(?, owner), maintainer = COALESCE(?, maintainer),
+			type = COALESCE(?, type), properties = COALESCE(?, properties), links = COALESCE(?, links), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND project_id = ? AND is_deleted = 0
+	`, updateFeatureMetadataRequest.Name, updateFeatureMetadataRequest.Description, updateFeatureMetadataRequest.Owner, updateFeatureMetadataRequest.Maintainer,
+		updateFeatureMetadataRequest.Type, encodeProperties(updateFeatureMetadataRequest.Properties), links, featureID, projectID)
+	if err != nil {
+		log.Println("Error updating feature in database:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "feature", ID: featureID}
+	}
+
+	if updateFeatureMetadataRequest.Tags != nil {
+		if err = setFeatureTags(ctx, tx, projectID, featureID, updateFeatureMetadataRequest.Tags); err != nil {
+			log.Println("Error setting feature tags:", err)
 			return err
 		}
 	}
@@ -292,7 +425,24 @@ func (db *DB) UpdateFeatures(ctx context.Context, projectID, featureID string, u
 	return nil
 }
 
-func (db *DB) DeleteFeature(ctx context.Context, projectID, featureID string) error {
+// setFeatureTags replaces the tags of a feature
+func setFeatureTags(ctx context.Context, tx *LoggerTx, projectID, featureID string, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM feature_tags WHERE feature_id = ?`, featureID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO feature_tags (feature_id, project_id, tag) VALUES (?, ?, ?)
+		`, featureID, projectID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFeature moves a feature to the trash. A non-empty etag must still
+// be the FeatureETag of the feature.
+func (db *DB) DeleteFeature(ctx context.Context, projectID, featureID, etag string) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("Error starting transaction:", err)
@@ -302,14 +452,157 @@ func (db *DB) DeleteFeature(ctx context.Context, projectID, featureID string) er
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	_, err = tx.ExecContext(ctx, `
+	if err = rejectManagedFeature(ctx, tx, projectID, featureID); err != nil {
+		return err
+	}
+
+	if etag != "" {
+		var current []*models.Feature
+		current, err = queryFeatureRows(ctx, tx, projectID, featureID)
+		if err != nil {
+			return err
+		}
+		if len(current) > 0 && models.FeatureETag(current) != etag {
+			err = &PreconditionFailedError{Message: "Feature changed since it was read", Current: current}
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `
 		UPDATE features
 		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
-		WHERE id = ? AND project_id = ?
+		WHERE id = ? AND project_id = ? AND is_deleted = 0
 	`, featureID, projectID)
 	if err != nil {
 		log.Println("Error deleting feature in database:", err)
 		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "feature", ID: featureID}
+	}
+
+	// rows in deleted environments must not come back when the environment
+	// is restored, they are revived with the feature instead
+	_, err = tx.ExecContext(ctx, `
+		UPDATE features
+		SET deleted_with = NULL
+		WHERE id = ? AND project_id = ? AND is_deleted = 1
+	`, featureID, projectID)
+	if err != nil {
+		log.Println("Error deleting feature in database:", err)
+		return err
+	}
+	return nil
+}
+
+// encodeProperties stores custom properties as a JSON object, nil when the
+// properties are nil so COALESCE keeps the stored ones
+func encodeProperties(properties map[string]string) *string {
+	if properties == nil {
+		return nil
+	}
+	bytes, _ := json.Marshal(properties)
+	encoded := string(bytes)
+	return &encoded
+}
+
+// rejectManagedFeature returns a ConflictError when the feature is managed
+// by a flags-as-code spec, such features only change when the spec is
+// applied. Missing features are left for the caller to report.
+func rejectManagedFeature(ctx context.Context, tx *LoggerTx, projectID, featureID string) error {
+	var label string
+	var managed bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT label, managed FROM features WHERE id = ? AND project_id = ? AND is_deleted = 0 LIMIT 1
+	`, featureID, projectID).Scan(&label, &managed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Println("Error querying feature from database:", err)
+		return err
+	}
+	if managed {
+		return &ConflictError{Message: fmt.Sprintf("Feature %q is managed as code, change it in its spec", label)}
+	}
 	return nil
 }
+
+// selectFeaturesSQL selects feature rows joined with their environment and
+// project in the column order expected by scanFeatures
+const selectFeaturesSQL = `
+	SELECT f.id, f.name, f.label, f.description, f.enabled, f.json_value, f.created_at, f.updated_at, f.deleted_at, f.environment_id, e.name, f.project_id, p.name, f.client_side_available,
+		f.owner, f.maintainer, f.type, f.properties, f.links, (SELECT json_group_array(tag) FROM (SELECT tag FROM feature_tags WHERE feature_id = f.id ORDER BY tag)), f.managed, f.version
+	FROM features f
+	INNER JOIN environments e ON f.environment_id = e.id
+	INNER JOIN projects p ON f.project_id = p.id
+`
+
+func scanFeatures(rows *sql.Rows) ([]*models.Feature, error) {
+	features := make([]*models.Feature, 0)
+
+	for rows.Next() {
+		var id, name, label, environmentID, projectID, environmentName, projectName, featureType string
+		var description, owner, maintainer, properties *string
+		var enabled, clientSideAvailable, managed, version int
+		var jsonValue, links, tags []byte
+		var createdAt, updatedAt time.Time
+		var deletedAt *time.Time
+
+		if err := rows.Scan(&id, &name, &label, &description, &enabled, &jsonValue, &createdAt, &updatedAt, &deletedAt, &environmentID, &environmentName, &projectID, &projectName, &clientSideAvailable, &owner, &maintainer, &featureType, &properties, &links, &tags, &managed, &version); err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+
+		var jsonVal models.JsonValue
+		json.Unmarshal(jsonValue, &jsonVal)
+
+		featureTags := make([]string, 0)
+		json.Unmarshal(tags, &featureTags)
+
+		featureLinks := make([]models.Link, 0)
+		json.Unmarshal(links, &featureLinks)
+
+		featureProperties := make(map[string]string)
+		if properties != nil {
+			json.Unmarshal([]byte(*properties), &featureProperties)
+		}
+
+		feature := &models.Feature{
+			ID:                  id,
+			Name:                name,
+			Label:               label,
+			Enabled:             enabled == 1,
+			JsonValue:           jsonVal,
+			ClientSideAvailable: clientSideAvailable == 1,
+			CreatedAt:           createdAt.Format(time.RFC3339),
+			UpdatedAt:           updatedAt.Format(time.RFC3339),
+			EnvironmentID:       environmentID,
+			EnvironmentName:     environmentName,
+			ProjectID:           projectID,
+			ProjectName:         projectName,
+			Tags:                featureTags,
+			Type:                featureType,
+			Properties:          featureProperties,
+			Links:               featureLinks,
+			Managed:             managed == 1,
+			Version:             version,
+		}
+		if deletedAt != nil {
+			feature.DeletedAt = deletedAt.Format(time.RFC3339)
+		}
+		if description != nil {
+			feature.Description = *description
+		}
+		if owner != nil {
+			feature.Owner = *owner
+		}
+		if maintainer != nil {
+			feature.Maintainer = *maintainer
+		}
+
+		features = append(features, feature)
+	}
+
+	return features, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"modulyn/pkg/models"
+)
+
+type LimitsDB interface {
+	GetProjectLimits(ctx context.Context, projectID string) (*models.Limits, error)
+	SetProjectLimits(ctx context.Context, projectID string, limits *models.Limits) error
+	DeleteProjectLimits(ctx context.Context, projectID string) error
+}
+
+// GetProjectLimits returns the overrides configured for the project, or nil
+// when the project uses the global limits
+func (db *DB) GetProjectLimits(ctx context.Context, projectID string) (*models.Limits, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT limits
+		FROM project_limits
+		WHERE project_id = ?
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying project limits from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	var data []byte
+	if err := rows.Scan(&data); err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+
+	var limits models.Limits
+	if err := json.Unmarshal(data, &limits); err != nil {
+		log.Println("Error decoding project limits:", err)
+		return nil, err
+	}
+
+	return &limits, nil
+}
+
+func (db *DB) SetProjectLimits(ctx context.Context, projectID string, limits *models.Limits) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	data, _ := json.Marshal(limits)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO project_limits
+		(project_id, limits)
+		VALUES
+		(?, ?)
+		ON CONFLICT (project_id) DO UPDATE SET limits = excluded.limits, updated_at = CURRENT_TIMESTAMP
+	`, projectID, data)
+	if err != nil {
+		log.Println("Error setting project limits in database:", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) DeleteProjectLimits(ctx context.Context, projectID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM project_limits
+		WHERE project_id = ?
+	`, projectID)
+	if err != nil {
+		log.Println("Error deleting project limits in database:", err)
+		return err
+	}
+	return nil
+}
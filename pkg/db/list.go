@@ -0,0 +1,89 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"modulyn/pkg/models"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortColumn is the expression a list is ordered by and the placeholder
+// the cursor value is compared with
+type sortColumn struct {
+	expr, param string
+}
+
+func textSortColumn(column string) sortColumn {
+	return sortColumn{column, "?"}
+}
+
+// timeSortColumn normalises timestamps so RFC 3339 cursor values compare
+// with the stored CURRENT_TIMESTAMP values
+func timeSortColumn(column string) sortColumn {
+	return sortColumn{"datetime(" + column + ")", "datetime(?)"}
+}
+
+// cursor identifies the last row of a page by its sort value and the keys
+// that make the order unique
+type cursor struct {
+	Value string   `json:"v"`
+	Keys  []string `json:"k"`
+}
+
+func encodeCursor(value string, keys ...string) string {
+	bytes, _ := json.Marshal(cursor{Value: value, Keys: keys})
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// keyset builds the clause continuing after the cursor, the ORDER BY and
+// the LIMIT of a page. The limit asks for one extra row to tell whether
+// another page follows.
+func keyset(options models.ListOptions, sortColumns map[string]sortColumn, keyColumns []string) (where string, args []any, orderBy string, err error) {
+	column, ok := sortColumns[options.Sort]
+	if !ok {
+		column = sortColumns[models.SortName]
+	}
+
+	direction, comparison := "ASC", ">"
+	if options.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	order := make([]string, 0, len(keyColumns)+1)
+	order = append(order, column.expr+" "+direction)
+	for _, key := range keyColumns {
+		order = append(order, key+" "+direction)
+	}
+	orderBy = "ORDER BY " + strings.Join(order, ", ")
+	if options.Limit > 0 {
+		orderBy += fmt.Sprintf(" LIMIT %d", options.Limit+1)
+	}
+
+	if options.Cursor == "" {
+		return "", nil, orderBy, nil
+	}
+
+	bytes, err := base64.RawURLEncoding.DecodeString(options.Cursor)
+	if err != nil {
+		return "", nil, "", ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(bytes, &c); err != nil || len(c.Keys) != len(keyColumns) {
+		return "", nil, "", ErrInvalidCursor
+	}
+
+	params := make([]string, 0, len(keyColumns)+1)
+	params = append(params, column.param)
+	args = append(args, c.Value)
+	for _, key := range c.Keys {
+		params = append(params, "?")
+		args = append(args, key)
+	}
+
+	where = fmt.Sprintf(" AND (%s, %s) %s (%s)", column.expr, strings.Join(keyColumns, ", "), comparison, strings.Join(params, ", "))
+	return where, args, orderBy, nil
+}
@@ -2,16 +2,22 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"modulyn/pkg/models"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type ProjectDB interface {
 	CreateProject(ctx context.Context, createProjectRequest *models.CreateProjectRequest) (string, error)
-	GetProjects(ctx context.Context) ([]*models.Project, error)
+	GetProjects(ctx context.Context, filter *models.ProjectFilter) ([]*models.Project, string, error)
+	GetProject(ctx context.Context, projectID string) (*models.Project, error)
+	GetProjectDetails(ctx context.Context, projectID string) (*models.ProjectDetails, error)
 	UpdateProject(ctx context.Context, projectID string, updateProjectRequest *models.UpdateProjectRequest) error
 	DeleteProject(ctx context.Context, projectID string) error
 }
@@ -53,40 +59,71 @@ func (db *DB) CreateProject(ctx context.Context, createProjectRequest *models.Cr
 		return "", err
 	}
 
+	environmentID := fmt.Sprintf("env-%s", projectID)
 	_, err = tx.ExecContext(ctx, `
 		INSERT INTO environments 
 		(id, name, project_id) 
 		VALUES 
 		(?, ?, ?)
-	`, fmt.Sprintf("sdk-%s", projectID), "Default", projectID)
+	`, environmentID, "Default", projectID)
 	if err != nil {
 		log.Println("Error inserting default environment in database:", err)
 		return "", err
 	}
 
+	for _, kind := range []string{models.SDKKeyKindServer, models.SDKKeyKindClient} {
+		if _, _, err = insertSDKKey(ctx, tx, projectID, environmentID, kind); err != nil {
+			log.Println("Error inserting sdk key for default environment:", err)
+			return "", err
+		}
+	}
+
 	return projectID, nil
 }
 
-func (db *DB) GetProjects(ctx context.Context) ([]*models.Project, error) {
+var projectSortColumns = map[string]sortColumn{
+	models.SortName:    textSortColumn("name"),
+	models.SortCreated: timeSortColumn("created_at"),
+	models.SortUpdated: timeSortColumn("updated_at"),
+}
+
+// GetProjects returns one page of projects and the cursor of the next page
+func (db *DB) GetProjects(ctx context.Context, filter *models.ProjectFilter) ([]*models.Project, string, error) {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("Error starting transaction:", err)
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		handleTxCommitOrRollback(tx, err)
 	}()
 
-	// Query the database for all projects
 	query := `
-		SELECT id, name
+		SELECT id, name, created_at, updated_at
 		FROM projects
 		WHERE is_deleted = 0
 	`
-	rows, err := tx.QueryContext(ctx, query, nil)
+	var args []any
+
+	if filter.ProjectIDs != nil {
+		if len(filter.ProjectIDs) == 0 {
+			return make([]*models.Project, 0), "", nil
+		}
+		query += " AND id IN (?" + strings.Repeat(", ?", len(filter.ProjectIDs)-1) + ")"
+		for _, projectID := range filter.ProjectIDs {
+			args = append(args, projectID)
+		}
+	}
+
+	where, keysetArgs, orderBy, err := keyset(filter.ListOptions, projectSortColumns, []string{"id"})
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := tx.QueryContext(ctx, query+where+"\n"+orderBy, append(args, keysetArgs...)...)
 	if err != nil {
 		log.Println("Error querying projects from database:", err)
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
@@ -94,18 +131,169 @@ func (db *DB) GetProjects(ctx context.Context) ([]*models.Project, error) {
 
 	for rows.Next() {
 		var id, name string
+		var createdAt, updatedAt time.Time
 
-		if err := rows.Scan(&id, &name); err != nil {
+		if err := rows.Scan(&id, &name, &createdAt, &updatedAt); err != nil {
 			log.Println("Error scanning row:", err)
-			return nil, err
+			return nil, "", err
 		}
 
 		projects = append(projects, &models.Project{
-			ID:   id,
-			Name: name,
+			ID:        id,
+			Name:      name,
+			CreatedAt: createdAt.Format(time.RFC3339),
+			UpdatedAt: updatedAt.Format(time.RFC3339),
+		})
+	}
+
+	if filter.Limit <= 0 || len(projects) <= filter.Limit {
+		return projects, "", nil
+	}
+
+	projects = projects[:filter.Limit]
+	last := projects[len(projects)-1]
+	return projects, encodeCursor(projectSortValue(last, filter.Sort), last.ID), nil
+}
+
+func projectSortValue(project *models.Project, sort string) string {
+	switch sort {
+	case models.SortCreated:
+		return project.CreatedAt
+	case models.SortUpdated:
+		return project.UpdatedAt
+	default:
+		return project.Name
+	}
+}
+
+func (db *DB) GetProject(ctx context.Context, projectID string) (*models.Project, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, created_at, updated_at
+		FROM projects
+		WHERE id = ? AND is_deleted = 0
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying project from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, &NotFoundError{Resource: "project", ID: projectID}
+	}
+
+	var id, name string
+	var createdAt, updatedAt time.Time
+	if err := rows.Scan(&id, &name, &createdAt, &updatedAt); err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+
+	return &models.Project{
+		ID:        id,
+		Name:      name,
+		CreatedAt: createdAt.Format(time.RFC3339),
+		UpdatedAt: updatedAt.Format(time.RFC3339),
+	}, nil
+}
+
+// GetProjectDetails returns a project with its environments, the number of
+// features enabled in each of them and when anything in it last changed.
+// Connected SDKs are not known to the database and left at zero.
+func (db *DB) GetProjectDetails(ctx context.Context, projectID string) (*models.ProjectDetails, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	var name string
+	var createdAt, updatedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT name, created_at, updated_at
+		FROM projects
+		WHERE id = ? AND is_deleted = 0
+	`, projectID).Scan(&name, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = &NotFoundError{Resource: "project", ID: projectID}
+		return nil, err
+	}
+	if err != nil {
+		log.Println("Error querying project from database:", err)
+		return nil, err
+	}
+
+	details := &models.ProjectDetails{
+		Project: models.Project{
+			ID:        projectID,
+			Name:      name,
+			CreatedAt: createdAt.Format(time.RFC3339),
+			UpdatedAt: updatedAt.Format(time.RFC3339),
+		},
+		Environments: make([]*models.EnvironmentSummary, 0),
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT e.id, e.name, e.required_role, e.requires_approval, COALESCE(SUM(f.enabled), 0)
+		FROM environments e
+		LEFT JOIN features f ON f.environment_id = e.id AND f.is_deleted = 0
+		WHERE e.project_id = ? AND e.is_deleted = 0
+		GROUP BY e.id
+		ORDER BY e.name
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying environments from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, name string
+		var requiredRole *string
+		var requiresApproval bool
+		var enabledFeatures int
+		if err = rows.Scan(&id, &name, &requiredRole, &requiresApproval, &enabledFeatures); err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		details.Environments = append(details.Environments, &models.EnvironmentSummary{
+			Environment:     *newEnvironment(id, name, requiredRole, requiresApproval),
+			EnabledFeatures: enabledFeatures,
 		})
 	}
-	return projects, nil
+	rows.Close()
+
+	var lastChangeAt *string
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT id) FROM features WHERE project_id = ? AND is_deleted = 0),
+			(SELECT MAX(changed_at) FROM (
+				SELECT datetime(updated_at) AS changed_at FROM projects WHERE id = ?
+				UNION ALL SELECT datetime(COALESCE(deleted_at, updated_at)) FROM environments WHERE project_id = ?
+				UNION ALL SELECT datetime(COALESCE(deleted_at, updated_at)) FROM features WHERE project_id = ?
+			))
+	`, projectID, projectID, projectID, projectID).Scan(&details.FeatureCount, &lastChangeAt)
+	if err != nil {
+		log.Println("Error summarizing project:", err)
+		return nil, err
+	}
+	if lastChangeAt != nil {
+		details.LastChangeAt = formatDateTime(*lastChangeAt)
+	}
+
+	return details, nil
 }
 
 func (db *DB) UpdateProject(ctx context.Context, projectID string, updateProjectRequest *models.UpdateProjectRequest) error {
@@ -121,13 +309,16 @@ func (db *DB) UpdateProject(ctx context.Context, projectID string, updateProject
 	query := `
 		UPDATE projects
 		SET name = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
+		WHERE id = ? AND is_deleted = 0
 	`
-	_, err = tx.ExecContext(ctx, query, updateProjectRequest.Name, projectID)
+	result, err := tx.ExecContext(ctx, query, updateProjectRequest.Name, projectID)
 	if err != nil {
 		log.Println("Error updating project in database:", err)
 		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "project", ID: projectID}
+	}
 	return nil
 }
 
@@ -141,6 +332,20 @@ func (db *DB) DeleteProject(ctx context.Context, projectID string) error {
 		handleTxCommitOrRollback(tx, err)
 	}()
 
+	updateProjectQuery := `
+		UPDATE projects
+		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND is_deleted = 0
+	`
+	result, err := tx.ExecContext(ctx, updateProjectQuery, projectID)
+	if err != nil {
+		log.Println("Error deleting project in database:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "project", ID: projectID}
+	}
+
 	getEnvironmentsQuery := `
 		SELECT id 
 		FROM environments 
@@ -155,7 +360,8 @@ func (db *DB) DeleteProject(ctx context.Context, projectID string) error {
 	var environmentIDs []string
 	for rows.Next() {
 		var environmentID string
-		if err := rows.Scan(&environmentID); err != nil {
+		if err = rows.Scan(&environmentID); err != nil {
+			rows.Close()
 			log.Println("Error scanning row:", err)
 			return err
 		}
@@ -163,13 +369,15 @@ func (db *DB) DeleteProject(ctx context.Context, projectID string) error {
 	}
 	rows.Close()
 
+	// rows are marked as deleted with the project so restoring it brings
+	// back exactly what this deletion removed
 	for _, environmentID := range environmentIDs {
 		updateFeatureQuery := `
 			UPDATE features
-			SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
-			WHERE environment_id = ? AND project_id = ?
+			SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP, deleted_with = ?
+			WHERE environment_id = ? AND project_id = ? AND is_deleted = 0
 		`
-		_, err := tx.ExecContext(ctx, updateFeatureQuery, environmentID, projectID)
+		_, err = tx.ExecContext(ctx, updateFeatureQuery, projectID, environmentID, projectID)
 		if err != nil {
 			log.Println("Error deleting features in database:", err)
 			return err
@@ -177,26 +385,15 @@ func (db *DB) DeleteProject(ctx context.Context, projectID string) error {
 
 		updateEnvironmentQuery := `
 			UPDATE environments
-			SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+			SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP, deleted_with = ?
 			WHERE id = ? AND project_id = ?
 		`
-		_, err = tx.ExecContext(ctx, updateEnvironmentQuery, environmentID, projectID)
+		_, err = tx.ExecContext(ctx, updateEnvironmentQuery, projectID, environmentID, projectID)
 		if err != nil {
 			log.Println("Error deleting environment in database:", err)
 			return err
 		}
 	}
 
-	updateProjectQuery := `
-		UPDATE projects
-		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`
-	_, err = tx.ExecContext(ctx, updateProjectQuery, projectID)
-	if err != nil {
-		log.Println("Error deleting project in database:", err)
-		return err
-	}
-
 	return nil
 }
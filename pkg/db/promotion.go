@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"modulyn/pkg/models"
+	"strings"
+)
+
+type PromotionDB interface {
+	GetPromotion(ctx context.Context, projectID string, promotionRequest *models.PromotionRequest) (*models.Promotion, error)
+	ApplyPromotion(ctx context.Context, projectID string, promotionRequest *models.PromotionRequest, requiresApproval bool, author *models.Principal) (*models.Promotion, error)
+}
+
+// GetPromotion previews a promotion without changing anything
+func (db *DB) GetPromotion(ctx context.Context, projectID string, promotionRequest *models.PromotionRequest) (*models.Promotion, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	promotion, err := diffPromotion(ctx, tx, projectID, promotionRequest)
+	if err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// ApplyPromotion recomputes the difference and writes all of it to the
+// target environment in one transaction. When the target requires
+// approval a change request is recorded per feature instead.
+func (db *DB) ApplyPromotion(ctx context.Context, projectID string, promotionRequest *models.PromotionRequest, requiresApproval bool, author *models.Principal) (*models.Promotion, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	promotion, err := diffPromotion(ctx, tx, projectID, promotionRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range promotion.Changes {
+		if change.Managed {
+			err = &ConflictError{Message: fmt.Sprintf("Feature %q is managed as code, change it in its spec", change.Label)}
+			return nil, err
+		}
+	}
+
+	for _, change := range promotion.Changes {
+		updateFeatureRequest := &models.UpdateFeatureRequest{
+			EnvironmentID: promotionRequest.TargetEnvironmentID,
+			Enabled:       change.Promoted.Enabled,
+			JsonValue:     change.Promoted.JsonValue,
+			Reason:        promotionRequest.Reason,
+		}
+
+		if requiresApproval {
+			var changeRequest *models.ChangeRequest
+			changeRequest, err = insertChangeRequest(ctx, tx, projectID, change.FeatureID, updateFeatureRequest, author)
+			if err != nil {
+				return nil, err
+			}
+			promotion.ChangeRequests = append(promotion.ChangeRequests, changeRequest)
+			continue
+		}
+
+		jsonValueBytes, _ := json.Marshal(updateFeatureRequest.JsonValue)
+		_, err = tx.ExecContext(ctx, `
+			UPDATE features
+			SET enabled = ?, json_value = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND environment_id = ? AND project_id = ? AND is_deleted = 0
+		`, updateFeatureRequest.Enabled, jsonValueBytes, change.FeatureID, updateFeatureRequest.EnvironmentID, projectID)
+		if err != nil {
+			log.Println("Error updating feature in database:", err)
+			return nil, err
+		}
+	}
+
+	promotion.Applied = !requiresApproval && len(promotion.Changes) > 0
+	return promotion, nil
+}
+
+// diffPromotion compares the selected features of the source environment
+// with their state in the target environment
+func diffPromotion(ctx context.Context, tx *LoggerTx, projectID string, promotionRequest *models.PromotionRequest) (*models.Promotion, error) {
+	query := `
+		SELECT s.id, s.name, s.label, s.enabled, s.json_value, t.enabled, t.json_value, s.managed
+		FROM features s
+		INNER JOIN features t ON t.id = s.id AND t.project_id = s.project_id AND t.environment_id = ? AND t.is_deleted = 0
+		WHERE s.project_id = ? AND s.environment_id = ? AND s.is_deleted = 0
+	`
+	args := []any{promotionRequest.TargetEnvironmentID, projectID, promotionRequest.SourceEnvironmentID}
+	if len(promotionRequest.FeatureIDs) > 0 {
+		query += " AND s.id IN (?" + strings.Repeat(", ?", len(promotionRequest.FeatureIDs)-1) + ")"
+		for _, featureID := range promotionRequest.FeatureIDs {
+			args = append(args, featureID)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, query+" ORDER BY s.name, s.id", args...)
+	if err != nil {
+		log.Println("Error querying features from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotion := &models.Promotion{
+		SourceEnvironmentID: promotionRequest.SourceEnvironmentID,
+		TargetEnvironmentID: promotionRequest.TargetEnvironmentID,
+		Changes:             make([]*models.PromotionChange, 0),
+	}
+	found := make(map[string]bool)
+	for rows.Next() {
+		var change models.PromotionChange
+		var sourceValue, targetValue []byte
+		if err := rows.Scan(&change.FeatureID, &change.Name, &change.Label, &change.Promoted.Enabled, &sourceValue, &change.Current.Enabled, &targetValue, &change.Managed); err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		json.Unmarshal(sourceValue, &change.Promoted.JsonValue)
+		json.Unmarshal(targetValue, &change.Current.JsonValue)
+		found[change.FeatureID] = true
+
+		if sameFeatureState(&change.Current, &change.Promoted) {
+			promotion.Unchanged++
+			continue
+		}
+		promotion.Changes = append(promotion.Changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error iterating rows:", err)
+		return nil, err
+	}
+
+	for _, featureID := range promotionRequest.FeatureIDs {
+		if !found[featureID] {
+			return nil, &NotFoundError{Resource: "feature", ID: featureID}
+		}
+	}
+	return promotion, nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"log"
+	"modulyn/pkg/models"
+	"time"
+)
+
+type PurgeDB interface {
+	PurgeDeleted(ctx context.Context, before time.Time, dryRun bool) (*models.PurgeReport, error)
+}
+
+// purgedProjectsSQL selects the projects deleted before the cutoff
+const purgedProjectsSQL = `SELECT id FROM projects WHERE is_deleted = 1 AND datetime(deleted_at) < datetime(?)`
+
+// purgeProjectSQL removes a project and everything that belongs to it
+var purgeProjectSQL = []string{
+	`DELETE FROM feature_tags WHERE project_id = ?`,
+	`DELETE FROM features WHERE project_id = ?`,
+	`DELETE FROM change_requests WHERE project_id = ?`,
+	`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE project_id = ?)`,
+	`DELETE FROM webhooks WHERE project_id = ?`,
+	`DELETE FROM sdk_keys WHERE project_id = ?`,
+	`DELETE FROM role_grants WHERE project_id = ?`,
+	`DELETE FROM project_limits WHERE project_id = ?`,
+	`DELETE FROM api_tokens WHERE project_id = ?`,
+	`DELETE FROM environments WHERE project_id = ?`,
+	`DELETE FROM projects WHERE id = ?`,
+}
+
+// purgeEnvironmentSQL removes an environment with its feature rows, sdk
+// keys and change requests
+var purgeEnvironmentSQL = []string{
+	`DELETE FROM features WHERE environment_id = ?`,
+	`DELETE FROM change_requests WHERE environment_id = ?`,
+	`DELETE FROM sdk_keys WHERE environment_id = ?`,
+	`DELETE FROM environments WHERE id = ?`,
+}
+
+// purgeFeatureSQL removes a feature in every environment with its tags
+// and change requests
+var purgeFeatureSQL = []string{
+	`DELETE FROM feature_tags WHERE feature_id = ?`,
+	`DELETE FROM change_requests WHERE feature_id = ?`,
+	`DELETE FROM features WHERE id = ?`,
+}
+
+// PurgeDeleted permanently removes the projects, environments and features
+// deleted before the given time. Rows deleted along with a project or an
+// environment go with it, a feature is only removed on its own once it is
+// deleted in every environment. A dry run reports without removing.
+func (db *DB) PurgeDeleted(ctx context.Context, before time.Time, dryRun bool) (*models.PurgeReport, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	cutoff := before.UTC().Format(time.DateTime)
+	report := &models.PurgeReport{
+		DryRun:        dryRun,
+		DeletedBefore: before.UTC().Format(time.RFC3339),
+	}
+
+	report.Projects, err = queryDeletedResources(ctx, tx, `
+		SELECT id, name, '', datetime(deleted_at)
+		FROM projects
+		WHERE id IN (`+purgedProjectsSQL+`)
+		ORDER BY datetime(deleted_at), id
+	`, cutoff)
+	if err != nil {
+		log.Println("Error querying projects to purge:", err)
+		return nil, err
+	}
+
+	report.Environments, err = queryDeletedResources(ctx, tx, `
+		SELECT id, name, '', datetime(deleted_at)
+		FROM environments
+		WHERE is_deleted = 1 AND (
+			project_id IN (`+purgedProjectsSQL+`)
+			OR (deleted_with IS NULL AND datetime(deleted_at) < datetime(?))
+		)
+		ORDER BY datetime(deleted_at), id
+	`, cutoff, cutoff)
+	if err != nil {
+		log.Println("Error querying environments to purge:", err)
+		return nil, err
+	}
+
+	report.Features, err = queryDeletedResources(ctx, tx, `
+		SELECT id, MAX(name), MAX(label), MAX(datetime(deleted_at))
+		FROM features
+		GROUP BY id
+		HAVING MIN(is_deleted) = 1 AND (
+			MAX(project_id IN (`+purgedProjectsSQL+`)) = 1
+			OR (COUNT(deleted_with) = 0 AND MAX(datetime(deleted_at)) < datetime(?))
+		)
+		ORDER BY 4, id
+	`, cutoff, cutoff)
+	if err != nil {
+		log.Println("Error querying features to purge:", err)
+		return nil, err
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, purge := range []struct {
+		statements []string
+		resources  []*models.DeletedResource
+	}{
+		{purgeFeatureSQL, report.Features},
+		{purgeEnvironmentSQL, report.Environments},
+		{purgeProjectSQL, report.Projects},
+	} {
+		for _, resource := range purge.resources {
+			for _, statement := range purge.statements {
+				if _, err = tx.ExecContext(ctx, statement, resource.ID); err != nil {
+					log.Println("Error purging deleted rows:", err)
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// tags of features that only existed in a purged environment
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM feature_tags WHERE feature_id NOT IN (SELECT id FROM features)
+	`)
+	if err != nil {
+		log.Println("Error purging orphaned feature tags:", err)
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"log"
+	"modulyn/pkg/models"
+	"time"
+)
+
+type RoleDB interface {
+	GetRoleGrants(ctx context.Context, projectID string) ([]*models.RoleGrant, error)
+	GetRoleGrant(ctx context.Context, projectID, tokenID string) (string, error)
+	GrantRole(ctx context.Context, projectID, tokenID, role string) error
+	RevokeRole(ctx context.Context, projectID, tokenID string) error
+}
+
+func (db *DB) GetRoleGrants(ctx context.Context, projectID string) ([]*models.RoleGrant, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT g.token_id, t.name, g.project_id, g.role, g.created_at
+		FROM role_grants g
+		INNER JOIN api_tokens t ON g.token_id = t.id
+		WHERE g.project_id = ? AND t.is_deleted = 0
+		ORDER BY t.name
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying role grants from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make([]*models.RoleGrant, 0)
+
+	for rows.Next() {
+		var tokenID, tokenName, projectID, role string
+		var createdAt time.Time
+
+		if err := rows.Scan(&tokenID, &tokenName, &projectID, &role, &createdAt); err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+
+		grants = append(grants, &models.RoleGrant{
+			TokenID:   tokenID,
+			TokenName: tokenName,
+			ProjectID: projectID,
+			Role:      role,
+			CreatedAt: createdAt.Format(time.RFC3339),
+		})
+	}
+
+	return grants, nil
+}
+
+// GetRoleGrant returns the role granted to the token on the project, or an
+// empty string when there is no grant
+func (db *DB) GetRoleGrant(ctx context.Context, projectID, tokenID string) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return "", err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT role
+		FROM role_grants
+		WHERE project_id = ? AND token_id = ?
+	`, projectID, tokenID)
+	if err != nil {
+		log.Println("Error querying role grant from database:", err)
+		return "", err
+	}
+	defer rows.Close()
+
+	var role string
+	for rows.Next() {
+		if err := rows.Scan(&role); err != nil {
+			log.Println("Error scanning row:", err)
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+func (db *DB) GrantRole(ctx context.Context, projectID, tokenID, role string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO role_grants
+		(token_id, project_id, role)
+		VALUES
+		(?, ?, ?)
+		ON CONFLICT (token_id, project_id) DO UPDATE SET role = excluded.role, updated_at = CURRENT_TIMESTAMP
+	`, tokenID, projectID, role)
+	if err != nil {
+		log.Println("Error granting role in database:", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) RevokeRole(ctx context.Context, projectID, tokenID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM role_grants
+		WHERE project_id = ? AND token_id = ?
+	`, projectID, tokenID)
+	if err != nil {
+		log.Println("Error revoking role in database:", err)
+		return err
+	}
+	return nil
+}
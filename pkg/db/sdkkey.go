@@ -0,0 +1,287 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"modulyn/pkg/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var sdkKeyPrefixes = map[string]string{
+	models.SDKKeyKindServer: "sdk-",
+	models.SDKKeyKindClient: "client-",
+}
+
+type SDKKeyDB interface {
+	GetSDKKeys(ctx context.Context, projectID, environmentID string) ([]*models.SDKKey, error)
+	RotateSDKKey(ctx context.Context, projectID, environmentID, kind string, gracePeriod time.Duration) (*models.SDKKey, []string, error)
+	RevokeSDKKey(ctx context.Context, projectID, environmentID, keyID string) error
+	ResolveSDKKey(ctx context.Context, key string) (*models.SDKKey, error)
+}
+
+// insertSDKKey creates a new key of the given kind for the environment
+// inside an existing transaction
+func insertSDKKey(ctx context.Context, tx *LoggerTx, projectID, environmentID, kind string) (string, string, error) {
+	newID, _ := uuid.NewRandom()
+	key := sdkKeyPrefixes[kind] + hex.EncodeToString(randomBytes(24))
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO sdk_keys
+		(id, key, kind, environment_id, project_id)
+		VALUES
+		(?, ?, ?, ?, ?)
+	`, newID.String(), key, kind, environmentID, projectID)
+	if err != nil {
+		return "", "", err
+	}
+	return newID.String(), key, nil
+}
+
+func (db *DB) GetSDKKeys(ctx context.Context, projectID, environmentID string) ([]*models.SDKKey, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, key, kind, environment_id, project_id, created_at, expires_at, revoked_at
+		FROM sdk_keys
+		WHERE project_id = ? AND environment_id = ?
+		ORDER BY kind DESC, created_at DESC
+	`, projectID, environmentID)
+	if err != nil {
+		log.Println("Error querying sdk keys from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*models.SDKKey, 0)
+
+	for rows.Next() {
+		key, err := scanSDKKey(rows.Scan)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// RotateSDKKey issues a new key of the given kind for the environment and
+// lets the currently active keys of that kind expire after the grace
+// period. It returns the new key and the IDs of the keys that are now
+// expiring.
+func (db *DB) RotateSDKKey(ctx context.Context, projectID, environmentID, kind string, gracePeriod time.Duration) (*models.SDKKey, []string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(gracePeriod)
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, expires_at
+		FROM sdk_keys
+		WHERE project_id = ? AND environment_id = ? AND kind = ? AND revoked_at IS NULL
+	`, projectID, environmentID, kind)
+	if err != nil {
+		log.Println("Error querying sdk keys from database:", err)
+		return nil, nil, err
+	}
+
+	var expiringIDs []string
+	for rows.Next() {
+		var id string
+		var currentExpiry *time.Time
+		if err = rows.Scan(&id, &currentExpiry); err != nil {
+			log.Println("Error scanning row:", err)
+			rows.Close()
+			return nil, nil, err
+		}
+		// keys already expiring sooner keep their earlier expiry
+		if currentExpiry == nil || currentExpiry.After(expiresAt) {
+			expiringIDs = append(expiringIDs, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range expiringIDs {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE sdk_keys
+			SET expires_at = ?
+			WHERE id = ?
+		`, expiresAt, id)
+		if err != nil {
+			log.Println("Error expiring sdk key in database:", err)
+			return nil, nil, err
+		}
+	}
+
+	keyID, key, err := insertSDKKey(ctx, tx, projectID, environmentID, kind)
+	if err != nil {
+		log.Println("Error inserting sdk key in database:", err)
+		return nil, nil, err
+	}
+
+	return &models.SDKKey{
+		ID:            keyID,
+		Key:           key,
+		Kind:          kind,
+		EnvironmentID: environmentID,
+		ProjectID:     projectID,
+		Active:        true,
+		CreatedAt:     now.Format(time.RFC3339),
+	}, expiringIDs, nil
+}
+
+func (db *DB) RevokeSDKKey(ctx context.Context, projectID, environmentID, keyID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE sdk_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND environment_id = ? AND project_id = ? AND revoked_at IS NULL
+	`, keyID, environmentID, projectID)
+	if err != nil {
+		log.Println("Error revoking sdk key in database:", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrNoRows
+	}
+	return nil
+}
+
+// ResolveSDKKey returns the key record for an SDK key that is neither
+// revoked nor expired and belongs to a live environment
+func (db *DB) ResolveSDKKey(ctx context.Context, key string) (*models.SDKKey, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT k.id, k.key, k.kind, k.environment_id, k.project_id, k.created_at, k.expires_at, k.revoked_at
+		FROM sdk_keys k
+		INNER JOIN environments e ON k.environment_id = e.id
+		WHERE k.key = ? AND e.is_deleted = 0
+	`, key)
+	if err != nil {
+		log.Println("Error querying sdk key from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrNoRows
+	}
+
+	sdkKey, err := scanSDKKey(rows.Scan)
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+	if !sdkKey.Active {
+		return nil, ErrNoRows
+	}
+
+	return sdkKey, nil
+}
+
+func scanSDKKey(scan func(dest ...any) error) (*models.SDKKey, error) {
+	var id, key, kind, environmentID, projectID string
+	var createdAt time.Time
+	var expiresAt, revokedAt *time.Time
+
+	if err := scan(&id, &key, &kind, &environmentID, &projectID, &createdAt, &expiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	sdkKey := &models.SDKKey{
+		ID:            id,
+		Key:           key,
+		Kind:          kind,
+		EnvironmentID: environmentID,
+		ProjectID:     projectID,
+		Active:        revokedAt == nil && (expiresAt == nil || expiresAt.After(time.Now())),
+		CreatedAt:     createdAt.Format(time.RFC3339),
+	}
+	if expiresAt != nil {
+		sdkKey.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+	if revokedAt != nil {
+		sdkKey.RevokedAt = revokedAt.Format(time.RFC3339)
+	}
+	return sdkKey, nil
+}
+
+// seedLegacySDKKeys registers the environment ID as the server SDK key of
+// every environment created before keys were decoupled, so connected SDKs
+// keep working until the key is rotated, and issues a client-side key to
+// environments that do not have one yet
+func seedLegacySDKKeys(db *DB) error {
+	for _, kind := range []string{models.SDKKeyKindServer, models.SDKKeyKindClient} {
+		rows, err := db.Query(`
+			SELECT e.id, e.project_id
+			FROM environments e
+			WHERE NOT EXISTS (SELECT 1 FROM sdk_keys k WHERE k.environment_id = e.id AND k.kind = ?)
+		`, kind)
+		if err != nil {
+			return err
+		}
+
+		var environments [][2]string
+		for rows.Next() {
+			var environmentID, projectID string
+			if err := rows.Scan(&environmentID, &projectID); err != nil {
+				rows.Close()
+				return err
+			}
+			environments = append(environments, [2]string{environmentID, projectID})
+		}
+		rows.Close()
+
+		for _, environment := range environments {
+			newID, _ := uuid.NewRandom()
+			key := environment[0]
+			if kind == models.SDKKeyKindClient {
+				key = sdkKeyPrefixes[kind] + hex.EncodeToString(randomBytes(24))
+			}
+			_, err := db.Exec(`
+				INSERT INTO sdk_keys
+				(id, key, kind, environment_id, project_id)
+				VALUES
+				(?, ?, ?, ?, ?)
+			`, newID.String(), key, kind, environment[0], environment[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
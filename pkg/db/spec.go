@@ -0,0 +1,256 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"modulyn/pkg/models"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+type SpecDB interface {
+	ApplySpecPlan(ctx context.Context, projectID string, plan *models.SpecPlan, author *models.Principal) ([]*models.ChangeRequest, error)
+}
+
+// ApplySpecPlan writes every change of a plan in one transaction. A change
+// whose environment or feature no longer exists means the project changed
+// after planning and rolls everything back. States in environments that
+// require approval are proposed as change requests, which it returns.
+func (db *DB) ApplySpecPlan(ctx context.Context, projectID string, plan *models.SpecPlan, author *models.Principal) ([]*models.ChangeRequest, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	if err = requireActiveProject(ctx, tx, projectID); err != nil {
+		return nil, err
+	}
+
+	environmentIDs, err := queryNameIDs(ctx, tx, `
+		SELECT name, MIN(id) FROM environments WHERE project_id = ? AND is_deleted = 0 GROUP BY name
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying environments from database:", err)
+		return nil, err
+	}
+	// environments the plan creates start without approvals
+	approvalEnvironments, err := queryNameIDs(ctx, tx, `
+		SELECT id, name FROM environments WHERE project_id = ? AND is_deleted = 0 AND requires_approval = 1
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying environments from database:", err)
+		return nil, err
+	}
+
+	changeRequests := make([]*models.ChangeRequest, 0)
+
+	for _, change := range plan.Changes {
+		switch {
+		case change.Resource == models.SpecResourceEnvironment && change.Action == models.SpecActionCreate:
+			environmentIDs[change.Key], err = insertEnvironment(ctx, tx, projectID, change.Key, requiredRole(change.EnvironmentSpec.RequiredRole), change.EnvironmentSpec.RequiresApproval)
+		case change.Resource == models.SpecResourceEnvironment && change.Action == models.SpecActionUpdate:
+			err = applySpecChange(ctx, tx, change, `
+				UPDATE environments
+				SET required_role = ?, requires_approval = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND project_id = ? AND is_deleted = 0
+			`, requiredRole(change.EnvironmentSpec.RequiredRole), change.EnvironmentSpec.RequiresApproval, change.ID, projectID)
+		case change.Resource == models.SpecResourceEnvironment && change.Action == models.SpecActionDelete:
+			err = applySpecChange(ctx, tx, change, `
+				UPDATE environments
+				SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND project_id = ? AND is_deleted = 0
+			`, change.ID, projectID)
+			if err == nil {
+				_, err = tx.ExecContext(ctx, `
+					UPDATE features
+					SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP, deleted_with = ?
+					WHERE environment_id = ? AND project_id = ? AND is_deleted = 0
+				`, change.ID, change.ID, projectID)
+			}
+		case change.Resource == models.SpecResourceFeature && change.Action == models.SpecActionCreate:
+			var proposed []*models.ChangeRequest
+			proposed, err = insertSpecFeature(ctx, tx, projectID, change.FeatureSpec, approvalEnvironments, author)
+			changeRequests = append(changeRequests, proposed...)
+		case change.Resource == models.SpecResourceFeature && change.Action == models.SpecActionUpdate:
+			feature := change.FeatureSpec
+			err = applySpecChange(ctx, tx, change, `
+				UPDATE features
+				SET name = ?, description = ?, client_side_available = ?, owner = ?, maintainer = ?, type = ?, properties = ?, links = ?, managed = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND project_id = ? AND is_deleted = 0
+			`, feature.Name, feature.Description, feature.ClientSideAvailable, feature.Owner, feature.Maintainer, cmp.Or(feature.Type, models.FeatureTypeRelease), encodeProperties(feature.Properties), specLinks(feature.Links), feature.Managed, change.ID, projectID)
+			if err == nil {
+				err = setFeatureTags(ctx, tx, projectID, change.ID, feature.Tags)
+			}
+		case change.Resource == models.SpecResourceFeature && change.Action == models.SpecActionDelete:
+			err = applySpecChange(ctx, tx, change, `
+				UPDATE features
+				SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND project_id = ? AND is_deleted = 0
+			`, change.ID, projectID)
+			if err == nil {
+				_, err = tx.ExecContext(ctx, `
+					UPDATE features
+					SET deleted_with = NULL
+					WHERE id = ? AND project_id = ? AND is_deleted = 1
+				`, change.ID, projectID)
+			}
+		case change.Resource == models.SpecResourceFeatureState && change.Action == models.SpecActionUpdate:
+			environmentID := environmentIDs[change.Environment]
+			if _, ok := approvalEnvironments[environmentID]; ok {
+				var changeRequest *models.ChangeRequest
+				changeRequest, err = proposeSpecState(ctx, tx, projectID, change.ID, environmentID, change.State, author)
+				if errors.Is(err, ErrNoRows) {
+					err = &ConflictError{Message: fmt.Sprintf("The %s %q changed since the plan was made, plan again", change.Resource, change.Key)}
+				}
+				if changeRequest != nil {
+					changeRequests = append(changeRequests, changeRequest)
+				}
+				break
+			}
+
+			jsonValueBytes, _ := json.Marshal(change.State.Targeting)
+			err = applySpecChange(ctx, tx, change, `
+				UPDATE features
+				SET enabled = ?, json_value = ?, updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND environment_id = ? AND project_id = ? AND is_deleted = 0
+			`, change.State.Enabled, jsonValueBytes, change.ID, environmentID, projectID)
+		default:
+			err = fmt.Errorf("unknown spec change %s %s", change.Action, change.Resource)
+		}
+		if err != nil {
+			log.Printf("Error applying %s of %s %q: %v", change.Action, change.Resource, change.Key, err)
+			return nil, err
+		}
+	}
+
+	return changeRequests, nil
+}
+
+// applySpecChange runs the statement of a change, which must affect a row
+func applySpecChange(ctx context.Context, tx *LoggerTx, change *models.SpecChange, query string, args ...any) error {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return &ConflictError{Message: fmt.Sprintf("The %s %q changed since the plan was made, plan again", change.Resource, change.Key)}
+	}
+	return nil
+}
+
+// insertSpecFeature adds a feature to every environment of the project
+// with the state the spec gives it there. The feature starts disabled in
+// environments that require approval and its state there is proposed.
+func insertSpecFeature(ctx context.Context, tx *LoggerTx, projectID string, feature *models.FeatureSpec, approvalEnvironments map[string]string, author *models.Principal) ([]*models.ChangeRequest, error) {
+	newID, _ := uuid.NewRandom()
+	featureID := newID.String()
+
+	environmentNames, err := queryNameIDs(ctx, tx, `
+		SELECT id, name FROM environments WHERE project_id = ? AND is_deleted = 0
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	for environmentID, name := range environmentNames {
+		var enabled bool
+		var jsonValueBytes []byte
+		_, requiresApproval := approvalEnvironments[environmentID]
+		if state, ok := feature.Environments[name]; ok && state != nil && !requiresApproval {
+			enabled = state.Enabled
+			jsonValueBytes, _ = json.Marshal(state.Targeting)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO features
+			(id, name, label, description, enabled, json_value, environment_id, project_id, client_side_available, owner, maintainer, type, properties, links, managed)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, featureID, feature.Name, feature.Key, feature.Description, enabled, jsonValueBytes, environmentID, projectID, feature.ClientSideAvailable, feature.Owner, feature.Maintainer, cmp.Or(feature.Type, models.FeatureTypeRelease), encodeProperties(feature.Properties), specLinks(feature.Links), feature.Managed)
+		if isUniqueConstraintError(err) {
+			return nil, &ConflictError{Message: fmt.Sprintf("Feature key %q is already used in this project", feature.Key)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setFeatureTags(ctx, tx, projectID, featureID, feature.Tags); err != nil {
+		return nil, err
+	}
+
+	changeRequests := make([]*models.ChangeRequest, 0)
+	for _, environmentID := range slices.Sorted(maps.Keys(approvalEnvironments)) {
+		state, ok := feature.Environments[approvalEnvironments[environmentID]]
+		if !ok || state == nil {
+			continue
+		}
+		changeRequest, err := proposeSpecState(ctx, tx, projectID, featureID, environmentID, state, author)
+		if err != nil {
+			return nil, err
+		}
+		if changeRequest != nil {
+			changeRequests = append(changeRequests, changeRequest)
+		}
+	}
+	return changeRequests, nil
+}
+
+// proposeSpecState records a change request for the state a spec gives a
+// feature in an environment that requires approval. Nothing is recorded
+// when the feature already has the state or a pending request from a spec
+// proposes it, so applying the same spec again adds no duplicates.
+func proposeSpecState(ctx context.Context, tx *LoggerTx, projectID, featureID, environmentID string, state *models.FeatureStateSpec, author *models.Principal) (*models.ChangeRequest, error) {
+	current, err := currentFeatureState(ctx, tx, projectID, featureID, environmentID)
+	if err != nil {
+		return nil, err
+	}
+	if sameFeatureState(current, &models.FeatureState{Enabled: state.Enabled, JsonValue: state.Targeting}) {
+		return nil, nil
+	}
+
+	updateFeatureRequest := &models.UpdateFeatureRequest{
+		EnvironmentID: environmentID,
+		Enabled:       state.Enabled,
+		JsonValue:     state.Targeting,
+		Reason:        "Applied from a spec",
+	}
+	proposedBytes, _ := json.Marshal(updateFeatureRequest)
+
+	var pending bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM change_requests
+			WHERE project_id = ? AND feature_id = ? AND environment_id = ? AND status = ? AND from_spec = 1 AND proposed = ?
+		)
+	`, projectID, featureID, environmentID, models.ChangeRequestPending, proposedBytes).Scan(&pending)
+	if err != nil || pending {
+		return nil, err
+	}
+
+	return recordChangeRequest(ctx, tx, projectID, featureID, updateFeatureRequest, author, true)
+}
+
+func specLinks(links []models.Link) []byte {
+	if links == nil {
+		return nil
+	}
+	bytes, _ := json.Marshal(links)
+	return bytes
+}
+
+func requiredRole(role string) *string {
+	if role == "" {
+		return nil
+	}
+	return &role
+}
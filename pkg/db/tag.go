@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"modulyn/pkg/models"
+	"strings"
+)
+
+type TagDB interface {
+	GetTags(ctx context.Context, projectID string) ([]*models.Tag, error)
+	RenameTag(ctx context.Context, projectID, tag, name string) ([]string, error)
+	DeleteTag(ctx context.Context, projectID, tag string) ([]string, error)
+}
+
+// GetTags returns the tags of the project's active features, by name
+func (db *DB) GetTags(ctx context.Context, projectID string) ([]*models.Tag, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	if err = requireActiveProject(ctx, tx, projectID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT t.tag, COUNT(DISTINCT t.feature_id)
+		FROM feature_tags t
+		JOIN features f ON f.id = t.feature_id AND f.is_deleted = 0
+		WHERE t.project_id = ?
+		GROUP BY t.tag
+		ORDER BY t.tag
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying tags from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]*models.Tag, 0)
+	for rows.Next() {
+		var tag models.Tag
+		if err = rows.Scan(&tag.Name, &tag.Features); err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	err = rows.Err()
+	return tags, err
+}
+
+// RenameTag moves a tag to a new name on every feature carrying it, deleted
+// features included, and returns the active features it changed
+func (db *DB) RenameTag(ctx context.Context, projectID, tag, name string) ([]string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	featureIDs, err := taggedFeatures(ctx, tx, projectID, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO feature_tags (feature_id, project_id, tag)
+		SELECT feature_id, project_id, ? FROM feature_tags WHERE project_id = ? AND tag = ?
+	`, name, projectID, tag)
+	if err != nil {
+		log.Println("Error renaming tag in database:", err)
+		return nil, err
+	}
+
+	if err = removeTag(ctx, tx, projectID, tag, featureIDs); err != nil {
+		return nil, err
+	}
+	return featureIDs, nil
+}
+
+// DeleteTag removes a tag from every feature carrying it, deleted features
+// included, and returns the active features it changed
+func (db *DB) DeleteTag(ctx context.Context, projectID, tag string) ([]string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	featureIDs, err := taggedFeatures(ctx, tx, projectID, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = removeTag(ctx, tx, projectID, tag, featureIDs); err != nil {
+		return nil, err
+	}
+	return featureIDs, nil
+}
+
+// taggedFeatures returns the active features carrying a tag. The tag must
+// be carried by a feature and not by one managed as code, whose tags only
+// change with its spec.
+func taggedFeatures(ctx context.Context, tx *LoggerTx, projectID, tag string) ([]string, error) {
+	if err := requireActiveProject(ctx, tx, projectID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT t.feature_id, MIN(f.label), MAX(f.is_deleted = 0), MAX(f.is_deleted = 0 AND f.managed = 1)
+		FROM feature_tags t
+		JOIN features f ON f.id = t.feature_id
+		WHERE t.project_id = ? AND t.tag = ?
+		GROUP BY t.feature_id
+		ORDER BY MIN(f.label)
+	`, projectID, tag)
+	if err != nil {
+		log.Println("Error querying tagged features from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	var featureIDs, managed []string
+	for rows.Next() {
+		var featureID, label string
+		var active, isManaged bool
+		if err := rows.Scan(&featureID, &label, &active, &isManaged); err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		found = true
+		if active {
+			featureIDs = append(featureIDs, featureID)
+		}
+		if isManaged {
+			managed = append(managed, fmt.Sprintf("%q", label))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, &NotFoundError{Resource: "tag", ID: tag}
+	}
+	if len(managed) > 0 {
+		return nil, &ConflictError{Message: fmt.Sprintf("Tag %q is carried by features managed as code: %s", tag, strings.Join(managed, ", "))}
+	}
+	return featureIDs, nil
+}
+
+// removeTag deletes a tag and marks the active features that carried it as
+// updated
+func removeTag(ctx context.Context, tx *LoggerTx, projectID, tag string, featureIDs []string) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM feature_tags WHERE project_id = ? AND tag = ?
+	`, projectID, tag)
+	if err != nil {
+		log.Println("Error deleting tag in database:", err)
+		return err
+	}
+
+	if len(featureIDs) == 0 {
+		return nil
+	}
+
+	args := []any{projectID}
+	for _, featureID := range featureIDs {
+		args = append(args, featureID)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE features
+		SET updated_at = CURRENT_TIMESTAMP
+		WHERE project_id = ? AND is_deleted = 0 AND id IN (?`+strings.Repeat(", ?", len(featureIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		log.Println("Error updating features in database:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"modulyn/pkg/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tokenSecretPrefix = "mdn_"
+	tokenDisplayChars = 12
+)
+
+type TokenDB interface {
+	CreateToken(ctx context.Context, createTokenRequest *models.CreateTokenRequest, expiresAt *time.Time) (*models.CreatedToken, error)
+	GetTokens(ctx context.Context, projectID string) ([]*models.Token, error)
+	GetToken(ctx context.Context, tokenID string) (*models.Token, error)
+	DeleteToken(ctx context.Context, tokenID string) error
+	AuthenticateToken(ctx context.Context, secret string) (*models.Principal, error)
+}
+
+// HashToken returns the representation of a token secret stored at rest
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (db *DB) CreateToken(ctx context.Context, createTokenRequest *models.CreateTokenRequest, expiresAt *time.Time) (*models.CreatedToken, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	newID, _ := uuid.NewRandom()
+	secret := tokenSecretPrefix + hex.EncodeToString(randomBytes(32))
+	prefix := secret[:tokenDisplayChars]
+
+	scope := models.TokenScopeOrganization
+	var projectID *string
+	if createTokenRequest.ProjectID != "" {
+		scope = models.TokenScopeProject
+		projectID = &createTokenRequest.ProjectID
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO api_tokens
+		(id, name, token_hash, token_prefix, scope, project_id, role, expires_at)
+		VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?)
+	`, newID.String(), createTokenRequest.Name, HashToken(secret), prefix, scope, projectID, createTokenRequest.Role, expiresAt)
+	if err != nil {
+		log.Println("Error inserting token in database:", err)
+		return nil, err
+	}
+
+	token := models.Token{
+		ID:        newID.String(),
+		Name:      createTokenRequest.Name,
+		Prefix:    prefix,
+		Scope:     scope,
+		ProjectID: createTokenRequest.ProjectID,
+		Role:      createTokenRequest.Role,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if expiresAt != nil {
+		token.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+
+	return &models.CreatedToken{
+		Token:  token,
+		Secret: secret,
+	}, nil
+}
+
+func (db *DB) GetTokens(ctx context.Context, projectID string) ([]*models.Token, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, token_prefix, scope, project_id, role, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE is_deleted = 0 AND (? = '' OR project_id = ?)
+		ORDER BY created_at
+	`, projectID, projectID)
+	if err != nil {
+		log.Println("Error querying tokens from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*models.Token, 0)
+
+	for rows.Next() {
+		token, err := scanToken(rows.Scan)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func (db *DB) GetToken(ctx context.Context, tokenID string) (*models.Token, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, token_prefix, scope, project_id, role, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE id = ? AND is_deleted = 0
+	`, tokenID)
+	if err != nil {
+		log.Println("Error querying token from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrNoRows
+	}
+
+	token, err := scanToken(rows.Scan)
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (db *DB) DeleteToken(ctx context.Context, tokenID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE api_tokens
+		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, tokenID)
+	if err != nil {
+		log.Println("Error deleting token in database:", err)
+		return err
+	}
+	return nil
+}
+
+// AuthenticateToken resolves a token secret to its principal. Unknown,
+// revoked and expired tokens all return ErrNoRows.
+func (db *DB) AuthenticateToken(ctx context.Context, secret string) (*models.Principal, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, scope, project_id, role, expires_at, last_used_at
+		FROM api_tokens
+		WHERE token_hash = ? AND is_deleted = 0
+	`, HashToken(secret))
+	if err != nil {
+		log.Println("Error querying token from database:", err)
+		return nil, err
+	}
+
+	var id, name, scope, role string
+	var projectID *string
+	var expiresAt, lastUsedAt *time.Time
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&id, &name, &scope, &projectID, &role, &expiresAt, &lastUsedAt)
+	}
+	rows.Close()
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+	if !found || (expiresAt != nil && expiresAt.Before(time.Now())) {
+		return nil, ErrNoRows
+	}
+
+	// only touch the row once a minute to keep authenticated reads cheap
+	if lastUsedAt == nil || time.Since(*lastUsedAt) > time.Minute {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE api_tokens
+			SET last_used_at = ?
+			WHERE id = ?
+		`, time.Now().UTC(), id)
+		if err != nil {
+			log.Println("Error updating token last used time:", err)
+			return nil, err
+		}
+	}
+
+	principal := &models.Principal{
+		TokenID: id,
+		Name:    name,
+		Scope:   scope,
+		Role:    role,
+	}
+	if projectID != nil {
+		principal.ProjectID = *projectID
+	}
+
+	return principal, nil
+}
+
+func scanToken(scan func(dest ...any) error) (*models.Token, error) {
+	var id, name, prefix, scope, role string
+	var projectID *string
+	var createdAt time.Time
+	var expiresAt, lastUsedAt *time.Time
+
+	if err := scan(&id, &name, &prefix, &scope, &projectID, &role, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+
+	token := &models.Token{
+		ID:        id,
+		Name:      name,
+		Prefix:    prefix,
+		Scope:     scope,
+		Role:      role,
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}
+	if projectID != nil {
+		token.ProjectID = *projectID
+	}
+	if expiresAt != nil {
+		token.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+	if lastUsedAt != nil {
+		token.LastUsedAt = lastUsedAt.Format(time.RFC3339)
+	}
+	return token, nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,335 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"modulyn/pkg/models"
+	"strings"
+)
+
+type TrashDB interface {
+	GetTrash(ctx context.Context, projectID string) (*models.Trash, error)
+	GetDeletedProjects(ctx context.Context, projectIDs []string) ([]*models.DeletedResource, error)
+	RestoreFeature(ctx context.Context, projectID, featureID string) error
+	RestoreEnvironment(ctx context.Context, projectID, environmentID string) error
+	RestoreProject(ctx context.Context, projectID string) error
+}
+
+func (db *DB) GetTrash(ctx context.Context, projectID string) (*models.Trash, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	if err = requireActiveProject(ctx, tx, projectID); err != nil {
+		return nil, err
+	}
+
+	trash := &models.Trash{}
+
+	trash.Environments, err = queryDeletedResources(ctx, tx, `
+		SELECT id, name, '', datetime(deleted_at)
+		FROM environments
+		WHERE project_id = ? AND is_deleted = 1 AND deleted_with IS NULL
+		ORDER BY datetime(deleted_at) DESC, id
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying deleted environments:", err)
+		return nil, err
+	}
+
+	trash.Features, err = queryDeletedResources(ctx, tx, `
+		SELECT id, MAX(name), MAX(label), MAX(datetime(deleted_at))
+		FROM features
+		WHERE project_id = ? AND is_deleted = 1 AND deleted_with IS NULL
+		AND id NOT IN (SELECT id FROM features WHERE project_id = ? AND is_deleted = 0)
+		GROUP BY id
+		ORDER BY 4 DESC, id
+	`, projectID, projectID)
+	if err != nil {
+		log.Println("Error querying deleted features:", err)
+		return nil, err
+	}
+
+	return trash, nil
+}
+
+// GetDeletedProjects lists deleted projects, restricted to projectIDs
+// unless it is nil
+func (db *DB) GetDeletedProjects(ctx context.Context, projectIDs []string) ([]*models.DeletedResource, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	query := `
+		SELECT id, name, '', datetime(deleted_at)
+		FROM projects
+		WHERE is_deleted = 1
+	`
+	var args []any
+	if projectIDs != nil {
+		if len(projectIDs) == 0 {
+			return make([]*models.DeletedResource, 0), nil
+		}
+		query += " AND id IN (?" + strings.Repeat(", ?", len(projectIDs)-1) + ")"
+		for _, projectID := range projectIDs {
+			args = append(args, projectID)
+		}
+	}
+
+	projects, err := queryDeletedResources(ctx, tx, query+" ORDER BY datetime(deleted_at) DESC, id", args...)
+	if err != nil {
+		log.Println("Error querying deleted projects:", err)
+		return nil, err
+	}
+	return projects, nil
+}
+
+// RestoreFeature revives a deleted feature in every active environment.
+// Environments created while it was deleted get a disabled copy and its
+// rows in deleted environments come back with those environments.
+func (db *DB) RestoreFeature(ctx context.Context, projectID, featureID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	if err = requireActiveProject(ctx, tx, projectID); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT label
+		FROM features
+		WHERE id = ? AND project_id = ? AND is_deleted = 1 AND deleted_with IS NULL
+		AND id NOT IN (SELECT id FROM features WHERE project_id = ? AND is_deleted = 0)
+		LIMIT 1
+	`, featureID, projectID, projectID)
+	if err != nil {
+		log.Println("Error querying deleted feature:", err)
+		return err
+	}
+	var label string
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&label)
+	}
+	rows.Close()
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return err
+	}
+	if !found {
+		err = &NotFoundError{Resource: "deleted feature", ID: featureID}
+		return err
+	}
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM features WHERE project_id = ? AND label = ? AND is_deleted = 0)
+	`, projectID, label).Scan(&taken)
+	if err != nil {
+		log.Println("Error checking feature key:", err)
+		return err
+	}
+	if taken {
+		err = &ConflictError{Message: fmt.Sprintf("Feature key %q is used by another feature, delete it before restoring this one", label)}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE features
+		SET is_deleted = 0, deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND project_id = ? AND is_deleted = 1 AND deleted_with IS NULL
+		AND environment_id IN (SELECT id FROM environments WHERE project_id = ? AND is_deleted = 0)
+	`, featureID, projectID, projectID)
+	if err != nil {
+		log.Println("Error restoring feature:", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE features
+		SET deleted_with = environment_id
+		WHERE id = ? AND project_id = ? AND is_deleted = 1 AND deleted_with IS NULL
+	`, featureID, projectID)
+	if err != nil {
+		log.Println("Error restoring feature:", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO features
+		(id, name, label, description, enabled, json_value, environment_id, project_id, client_side_available, owner, maintainer, type, properties, links, managed)
+		SELECT f.id, f.name, f.label, f.description, 0, NULL, e.id, f.project_id, f.client_side_available, f.owner, f.maintainer, f.type, f.properties, f.links, f.managed
+		FROM environments e
+		INNER JOIN (SELECT * FROM features WHERE id = ? AND project_id = ? AND is_deleted = 0 LIMIT 1) f ON f.project_id = e.project_id
+		WHERE e.is_deleted = 0
+		AND NOT EXISTS (SELECT 1 FROM features x WHERE x.id = f.id AND x.environment_id = e.id)
+	`, featureID, projectID)
+	if err != nil {
+		log.Println("Error inserting feature for new environments:", err)
+		return err
+	}
+
+	return nil
+}
+
+// RestoreEnvironment revives a deleted environment with its sdk keys and
+// the features deleted along with it. Features created while it was
+// deleted are added disabled, like for a new environment.
+func (db *DB) RestoreEnvironment(ctx context.Context, projectID, environmentID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	if err = requireActiveProject(ctx, tx, projectID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE environments
+		SET is_deleted = 0, deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND project_id = ? AND is_deleted = 1 AND deleted_with IS NULL
+	`, environmentID, projectID)
+	if err != nil {
+		log.Println("Error restoring environment:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		err = &NotFoundError{Resource: "deleted environment", ID: environmentID}
+		return err
+	}
+
+	// a feature whose key was taken over while the environment was deleted
+	// stays deleted, the feature now holding the key is added below
+	_, err = tx.ExecContext(ctx, `
+		UPDATE features
+		SET is_deleted = 0, deleted_at = NULL, deleted_with = NULL
+		WHERE environment_id = ? AND project_id = ? AND deleted_with = ?
+		AND NOT EXISTS (
+			SELECT 1 FROM features other
+			WHERE other.project_id = features.project_id AND other.label = features.label
+			AND other.id != features.id AND other.is_deleted = 0
+		)
+	`, environmentID, projectID, environmentID)
+	if err != nil {
+		log.Println("Error restoring features:", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO features
+		(id, name, label, description, enabled, json_value, environment_id, project_id, client_side_available, owner, maintainer, type, properties, links, managed)
+		SELECT f.id, MAX(f.name), MAX(f.label), MAX(f.description), 0, NULL, ?, f.project_id, MAX(f.client_side_available), MAX(f.owner), MAX(f.maintainer), MAX(f.type), MAX(f.properties), MAX(f.links), MAX(f.managed)
+		FROM features f
+		WHERE f.project_id = ? AND f.is_deleted = 0
+		AND NOT EXISTS (SELECT 1 FROM features x WHERE x.id = f.id AND x.environment_id = ?)
+		GROUP BY f.id
+	`, environmentID, projectID, environmentID)
+	if err != nil {
+		log.Println("Error inserting features for restored environment:", err)
+		return err
+	}
+
+	return nil
+}
+
+// RestoreProject revives a deleted project with the environments and
+// features that were deleted along with it
+func (db *DB) RestoreProject(ctx context.Context, projectID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE projects
+		SET is_deleted = 0, deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND is_deleted = 1
+	`, projectID)
+	if err != nil {
+		log.Println("Error restoring project:", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		err = &NotFoundError{Resource: "deleted project", ID: projectID}
+		return err
+	}
+
+	for _, table := range []string{"environments", "features"} {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s
+			SET is_deleted = 0, deleted_at = NULL, deleted_with = NULL
+			WHERE project_id = ? AND deleted_with = ?
+		`, table), projectID, projectID)
+		if err != nil {
+			log.Printf("Error restoring %s: %v", table, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func requireActiveProject(ctx context.Context, tx *LoggerTx, projectID string) error {
+	var active bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM projects WHERE id = ? AND is_deleted = 0)
+	`, projectID).Scan(&active)
+	if err != nil {
+		log.Println("Error querying project from database:", err)
+		return err
+	}
+	if !active {
+		return &NotFoundError{Resource: "project", ID: projectID}
+	}
+	return nil
+}
+
+// queryDeletedResources scans id, name, label and deleted_at, the latter
+// formatted by datetime()
+func queryDeletedResources(ctx context.Context, tx *LoggerTx, query string, args ...any) ([]*models.DeletedResource, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resources := make([]*models.DeletedResource, 0)
+	for rows.Next() {
+		var resource models.DeletedResource
+		var deletedAt *string
+		if err := rows.Scan(&resource.ID, &resource.Name, &resource.Label, &deletedAt); err != nil {
+			return nil, err
+		}
+		if deletedAt != nil {
+			resource.DeletedAt = formatDateTime(*deletedAt)
+		}
+		resources = append(resources, &resource)
+	}
+	return resources, rows.Err()
+}
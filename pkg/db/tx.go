@@ -5,12 +5,18 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"modulyn/pkg/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// LoggerTx wraps a standard sql.Tx to add logging
+// LoggerTx wraps a standard sql.Tx to add logging and tracing
 type LoggerTx struct {
 	*sql.Tx
-	id string // Unique ID for the transaction for logging
+	id   string     // Unique ID for the transaction for logging
+	span trace.Span // Span covering the transaction until commit or rollback
 }
 
 func (ldb *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*LoggerTx, error) {
@@ -19,17 +25,29 @@ func (ldb *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*LoggerTx, err
 		return nil, err
 	}
 	txID := fmt.Sprintf("tx-%s", ctx.Value(CorrelationKey))
+	_, span := telemetry.Tracer().Start(ctx, "db.transaction",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "sqlite")),
+	)
 	log.Printf("Transaction %s: Started", txID)
-	return &LoggerTx{Tx: tx, id: txID}, nil
+	return &LoggerTx{Tx: tx, id: txID, span: span}, nil
 }
 
 func (ltx *LoggerTx) Commit() error {
 	log.Printf("Transaction %s: Committing", ltx.id)
-	return ltx.Tx.Commit()
+	defer ltx.span.End()
+	err := ltx.Tx.Commit()
+	if err != nil {
+		ltx.span.RecordError(err)
+		ltx.span.SetStatus(codes.Error, "commit failed")
+	}
+	return err
 }
 
 func (ltx *LoggerTx) Rollback() error {
 	log.Printf("Transaction %s: Rolling back", ltx.id)
+	defer ltx.span.End()
+	ltx.span.SetStatus(codes.Error, "rolled back")
 	return ltx.Tx.Rollback()
 }
 
@@ -39,7 +57,14 @@ func (ltx *LoggerTx) ExecContext(ctx context.Context, query string, args ...any)
 	} else {
 		log.Printf("Transaction %s: Executing query", ltx.id)
 	}
-	return ltx.Tx.ExecContext(ctx, query, args...)
+	ctx, span := ltx.startQuerySpan(ctx, "db.exec", query)
+	defer span.End()
+	result, err := ltx.Tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
 }
 
 func (ltx *LoggerTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
@@ -48,5 +73,41 @@ func (ltx *LoggerTx) QueryContext(ctx context.Context, query string, args ...any
 	} else {
 		log.Printf("Transaction %s: Querying", ltx.id)
 	}
-	return ltx.Tx.QueryContext(ctx, query, args...)
+	ctx, span := ltx.startQuerySpan(ctx, "db.query", query)
+	defer span.End()
+	rows, err := ltx.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return rows, err
+}
+
+func (ltx *LoggerTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if EnableSqlLogging {
+		log.Printf("Transaction %s: Querying: %s", ltx.id, interpolateSQL(query, args...))
+	} else {
+		log.Printf("Transaction %s: Querying", ltx.id)
+	}
+	ctx, span := ltx.startQuerySpan(ctx, "db.query", query)
+	defer span.End()
+	row := ltx.Tx.QueryRowContext(ctx, query, args...)
+	if err := row.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return row
+}
+
+// startQuerySpan starts a child span of the transaction span. Only the
+// statement text is recorded, never the bound arguments.
+func (ltx *LoggerTx) startQuerySpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return telemetry.Tracer().Start(trace.ContextWithSpan(ctx, ltx.span), name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.statement", query),
+			attribute.String("db.transaction_id", ltx.id),
+		),
+	)
 }
@@ -1,8 +1,14 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 func interpolateSQL(query string, args ...any) string {
@@ -34,8 +40,142 @@ func interpolateSQL(query string, args ...any) string {
 	return b.String()
 }
 
+// transformLabel derives a feature key from its name, runs of characters
+// that are not allowed in a key become a single '-'
 func transformLabel(featureName string) string {
-	allLower := strings.ToLower(featureName)
-	allLower = strings.ReplaceAll(allLower, " ", "-")
-	return allLower
+	var b strings.Builder
+	for _, r := range strings.ToLower(featureName) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	label := strings.TrimRight(b.String(), "-_.")
+	if label == "" {
+		return "feature"
+	}
+	return label
+}
+
+// formatDateTime converts a timestamp formatted by sqlite's datetime() to
+// RFC 3339
+func formatDateTime(value string) string {
+	t, err := time.Parse(time.DateTime, value)
+	if err != nil {
+		return value
+	}
+	return t.Format(time.RFC3339)
+}
+
+// isUniqueConstraintError reports whether a statement violated a unique index
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// addColumnIfNotExists adds a column to an existing table, sqlite has no
+// IF NOT EXISTS clause for ALTER TABLE so the schema is checked first
+func addColumnIfNotExists(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue *string
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// dedupeFeatureKeys renames every feature sharing its key with a feature of
+// a lower id in the same project, so the unique key index can be built. The
+// new key is the old one with the start of the id appended, followed by a
+// counter when that is taken too, and each rename is logged so operators
+// can update the SDKs evaluating the old key.
+func dedupeFeatureKeys(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT DISTINCT f.project_id, f.id, f.label
+		FROM features f
+		WHERE f.is_deleted = 0 AND EXISTS (
+			SELECT 1 FROM features other
+			WHERE other.project_id = f.project_id
+			AND other.environment_id = f.environment_id
+			AND other.label = f.label
+			AND other.is_deleted = 0
+			AND other.id < f.id
+		)
+		ORDER BY f.project_id, f.label, f.id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type duplicate struct {
+		projectID, featureID, label string
+	}
+	var duplicates []duplicate
+	for rows.Next() {
+		var d duplicate
+		if err := rows.Scan(&d.projectID, &d.featureID, &d.label); err != nil {
+			return err
+		}
+		duplicates = append(duplicates, d)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, d := range duplicates {
+		prefix := d.label + "-" + d.featureID[:min(8, len(d.featureID))]
+		label := prefix
+		for n := 2; ; n++ {
+			var taken bool
+			err := tx.QueryRow(`
+				SELECT EXISTS (SELECT 1 FROM features WHERE project_id = ? AND label = ? AND is_deleted = 0)
+			`, d.projectID, label).Scan(&taken)
+			if err != nil {
+				return err
+			}
+			if !taken {
+				break
+			}
+			label = fmt.Sprintf("%s-%d", prefix, n)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE features SET label = ?
+			WHERE project_id = ? AND id = ? AND label = ? AND is_deleted = 0
+		`, label, d.projectID, d.featureID, d.label)
+		if err != nil {
+			return err
+		}
+		log.Printf("Renamed duplicate feature key %q of feature %s in project %s to %q", d.label, d.featureID, d.projectID, label)
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,484 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"modulyn/pkg/models"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WebhookDB interface {
+	CreateWebhook(ctx context.Context, projectID string, createWebhookRequest *models.CreateWebhookRequest) (*models.CreatedWebhook, error)
+	GetWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error)
+	GetWebhook(ctx context.Context, projectID, webhookID string) (*models.Webhook, error)
+	UpdateWebhook(ctx context.Context, projectID, webhookID string, updateWebhookRequest *models.UpdateWebhookRequest) error
+	DeleteWebhook(ctx context.Context, projectID, webhookID string) error
+	EnqueueWebhookDeliveries(ctx context.Context, projectID, eventType string, payload []byte) (int, error)
+	CreateWebhookDelivery(ctx context.Context, webhookID, eventType string, payload []byte) (*models.WebhookDelivery, error)
+	GetDueWebhookDeliveries(ctx context.Context, limit int) ([]*models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, delivery *models.WebhookDelivery, nextAttemptAt *time.Time) error
+	GetWebhookDeliveries(ctx context.Context, webhookID string, limit int) ([]*models.WebhookDelivery, error)
+}
+
+// webhookSecretPrefix marks generated webhook signing secrets
+const webhookSecretPrefix = "whsec_"
+
+const selectWebhookDeliveriesSQL = `
+	SELECT d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts, d.response_status, d.error, d.created_at, d.next_attempt_at, d.delivered_at, w.url, w.secret
+	FROM webhook_deliveries d
+	JOIN webhooks w ON w.id = d.webhook_id
+`
+
+func (db *DB) CreateWebhook(ctx context.Context, projectID string, createWebhookRequest *models.CreateWebhookRequest) (*models.CreatedWebhook, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	newID, _ := uuid.NewRandom()
+	eventTypes := createWebhookRequest.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	eventTypesBytes, _ := json.Marshal(eventTypes)
+
+	secret := createWebhookRequest.Secret
+	if secret == "" {
+		secret = webhookSecretPrefix + hex.EncodeToString(randomBytes(24))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhooks
+		(id, project_id, url, secret, event_types)
+		VALUES
+		(?, ?, ?, ?, ?)
+	`, newID.String(), projectID, createWebhookRequest.URL, secret, eventTypesBytes)
+	if err != nil {
+		log.Println("Error inserting webhook in database:", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	return &models.CreatedWebhook{
+		Webhook: models.Webhook{
+			ID:         newID.String(),
+			ProjectID:  projectID,
+			URL:        createWebhookRequest.URL,
+			EventTypes: eventTypes,
+			Active:     true,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+		Secret: secret,
+	}, nil
+}
+
+func (db *DB) GetWebhooks(ctx context.Context, projectID string) ([]*models.Webhook, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, project_id, url, event_types, active, created_at, updated_at
+		FROM webhooks
+		WHERE project_id = ? AND is_deleted = 0
+		ORDER BY created_at
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying webhooks from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*models.Webhook, 0)
+
+	for rows.Next() {
+		webhook, err := scanWebhook(rows.Scan)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+func (db *DB) GetWebhook(ctx context.Context, projectID, webhookID string) (*models.Webhook, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, project_id, url, event_types, active, created_at, updated_at
+		FROM webhooks
+		WHERE project_id = ? AND id = ? AND is_deleted = 0
+	`, projectID, webhookID)
+	if err != nil {
+		log.Println("Error querying webhook from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrNoRows
+	}
+
+	webhook, err := scanWebhook(rows.Scan)
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (db *DB) UpdateWebhook(ctx context.Context, projectID, webhookID string, updateWebhookRequest *models.UpdateWebhookRequest) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	var eventTypes *string
+	if updateWebhookRequest.EventTypes != nil {
+		eventTypesBytes, _ := json.Marshal(updateWebhookRequest.EventTypes)
+		value := string(eventTypesBytes)
+		eventTypes = &value
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE webhooks
+		SET url = COALESCE(?, url), event_types = COALESCE(?, event_types), active = COALESCE(?, active), updated_at = CURRENT_TIMESTAMP
+		WHERE project_id = ? AND id = ? AND is_deleted = 0
+	`, updateWebhookRequest.URL, eventTypes, updateWebhookRequest.Active, projectID, webhookID)
+	if err != nil {
+		log.Println("Error updating webhook in database:", err)
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNoRows
+	}
+	return nil
+}
+
+func (db *DB) DeleteWebhook(ctx context.Context, projectID, webhookID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE webhooks
+		SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP
+		WHERE project_id = ? AND id = ? AND is_deleted = 0
+	`, projectID, webhookID)
+	if err != nil {
+		log.Println("Error deleting webhook in database:", err)
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNoRows
+	}
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues the payload for every active webhook of
+// the project subscribed to the event type and returns how many were queued
+func (db *DB) EnqueueWebhookDeliveries(ctx context.Context, projectID, eventType string, payload []byte) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return 0, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, event_types
+		FROM webhooks
+		WHERE project_id = ? AND active = 1 AND is_deleted = 0
+	`, projectID)
+	if err != nil {
+		log.Println("Error querying webhooks from database:", err)
+		return 0, err
+	}
+
+	var webhookIDs []string
+	for rows.Next() {
+		var id string
+		var eventTypesBytes []byte
+		if err = rows.Scan(&id, &eventTypesBytes); err != nil {
+			rows.Close()
+			log.Println("Error scanning row:", err)
+			return 0, err
+		}
+
+		var eventTypes []string
+		json.Unmarshal(eventTypesBytes, &eventTypes)
+		if len(eventTypes) == 0 || slices.Contains(eventTypes, eventType) {
+			webhookIDs = append(webhookIDs, id)
+		}
+	}
+	rows.Close()
+
+	now := time.Now().UTC()
+	for _, webhookID := range webhookIDs {
+		newID, _ := uuid.NewRandom()
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries
+			(id, webhook_id, event_type, payload, status, next_attempt_at, created_at)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?)
+		`, newID.String(), webhookID, eventType, payload, models.WebhookDeliveryPending, now, now)
+		if err != nil {
+			log.Println("Error inserting webhook delivery in database:", err)
+			return 0, err
+		}
+	}
+
+	return len(webhookIDs), nil
+}
+
+// CreateWebhookDelivery records a payload for a single webhook regardless
+// of its event type filter, used to send test events
+func (db *DB) CreateWebhookDelivery(ctx context.Context, webhookID, eventType string, payload []byte) (*models.WebhookDelivery, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	// no next attempt so the background dispatcher leaves it to the caller
+	newID, _ := uuid.NewRandom()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries
+		(id, webhook_id, event_type, payload, status, created_at)
+		VALUES
+		(?, ?, ?, ?, ?, ?)
+	`, newID.String(), webhookID, eventType, payload, models.WebhookDeliveryPending, time.Now().UTC())
+	if err != nil {
+		log.Println("Error inserting webhook delivery in database:", err)
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, selectWebhookDeliveriesSQL+`
+		WHERE d.id = ?
+	`, newID.String())
+	if err != nil {
+		log.Println("Error querying webhook delivery from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrNoRows
+	}
+
+	delivery, err := scanWebhookDelivery(rows.Scan)
+	if err != nil {
+		log.Println("Error scanning row:", err)
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// GetDueWebhookDeliveries returns pending deliveries of active webhooks
+// whose next attempt is due, oldest first
+func (db *DB) GetDueWebhookDeliveries(ctx context.Context, limit int) ([]*models.WebhookDelivery, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, selectWebhookDeliveriesSQL+`
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND w.active = 1 AND w.is_deleted = 0
+		ORDER BY d.next_attempt_at
+		LIMIT ?
+	`, models.WebhookDeliveryPending, time.Now().UTC(), limit)
+	if err != nil {
+		log.Println("Error querying webhook deliveries from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows.Scan)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt stores the outcome of a delivery attempt, a non nil
+// nextAttemptAt schedules a retry
+func (db *DB) RecordWebhookAttempt(ctx context.Context, delivery *models.WebhookDelivery, nextAttemptAt *time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	var deliveredAt *time.Time
+	if delivery.Status == models.WebhookDeliverySucceeded {
+		now := time.Now().UTC()
+		deliveredAt = &now
+		delivery.DeliveredAt = now.Format(time.RFC3339)
+	}
+	delivery.NextAttemptAt = ""
+	if nextAttemptAt != nil {
+		delivery.NextAttemptAt = nextAttemptAt.Format(time.RFC3339)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, response_status = ?, error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE id = ?
+	`, delivery.Status, delivery.Attempts, delivery.ResponseStatus, delivery.Error, nextAttemptAt, deliveredAt, delivery.ID)
+	if err != nil {
+		log.Println("Error updating webhook delivery in database:", err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetWebhookDeliveries(ctx context.Context, webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		return nil, err
+	}
+	defer func() {
+		handleTxCommitOrRollback(tx, err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, selectWebhookDeliveriesSQL+`
+		WHERE d.webhook_id = ?
+		ORDER BY d.created_at DESC
+		LIMIT ?
+	`, webhookID, limit)
+	if err != nil {
+		log.Println("Error querying webhook deliveries from database:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows.Scan)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func scanWebhook(scan func(dest ...any) error) (*models.Webhook, error) {
+	var id, projectID, url string
+	var eventTypesBytes []byte
+	var active bool
+	var createdAt, updatedAt time.Time
+
+	if err := scan(&id, &projectID, &url, &eventTypesBytes, &active, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	webhook := &models.Webhook{
+		ID:         id,
+		ProjectID:  projectID,
+		URL:        url,
+		EventTypes: []string{},
+		Active:     active,
+		CreatedAt:  createdAt.Format(time.RFC3339),
+		UpdatedAt:  updatedAt.Format(time.RFC3339),
+	}
+	json.Unmarshal(eventTypesBytes, &webhook.EventTypes)
+	return webhook, nil
+}
+
+func scanWebhookDelivery(scan func(dest ...any) error) (*models.WebhookDelivery, error) {
+	var id, webhookID, eventType, status, url, secret string
+	var payload []byte
+	var attempts int
+	var responseStatus *int
+	var errorMessage *string
+	var createdAt time.Time
+	var nextAttemptAt, deliveredAt *time.Time
+
+	if err := scan(&id, &webhookID, &eventType, &payload, &status, &attempts, &responseStatus, &errorMessage, &createdAt, &nextAttemptAt, &deliveredAt, &url, &secret); err != nil {
+		return nil, err
+	}
+
+	delivery := &models.WebhookDelivery{
+		ID:        id,
+		WebhookID: webhookID,
+		EventType: eventType,
+		Payload:   payload,
+		Status:    status,
+		Attempts:  attempts,
+		CreatedAt: createdAt.Format(time.RFC3339),
+		URL:       url,
+		Secret:    secret,
+	}
+	if responseStatus != nil {
+		delivery.ResponseStatus = *responseStatus
+	}
+	if errorMessage != nil {
+		delivery.Error = *errorMessage
+	}
+	if nextAttemptAt != nil && status == models.WebhookDeliveryPending {
+		delivery.NextAttemptAt = nextAttemptAt.Format(time.RFC3339)
+	}
+	if deliveredAt != nil {
+		delivery.DeliveredAt = deliveredAt.Format(time.RFC3339)
+	}
+	return delivery, nil
+}
@@ -0,0 +1,39 @@
+package evaluation
+
+import (
+	"modulyn/pkg/models"
+	"slices"
+)
+
+// Evaluate returns the value of a feature for a user context. A disabled
+// feature is always off. When targeting is enabled on the json value the
+// feature is only on for contexts whose attribute named by the targeting key
+// holds one of the targeted values.
+func Evaluate(feature *models.Feature, userContext map[string]string) bool {
+	if !feature.Enabled {
+		return false
+	}
+
+	targeting := feature.JsonValue
+	if !targeting.Enabled {
+		return true
+	}
+
+	value, ok := userContext[targeting.Key]
+	return ok && slices.Contains(targeting.Values, value)
+}
+
+// EvaluateAll evaluates the features that are available to client-side SDKs
+func EvaluateAll(features []*models.Feature, userContext map[string]string) []models.EvaluatedFeature {
+	evaluated := make([]models.EvaluatedFeature, 0, len(features))
+	for _, feature := range features {
+		if !feature.ClientSideAvailable {
+			continue
+		}
+		evaluated = append(evaluated, models.EvaluatedFeature{
+			Key:   feature.Label,
+			Value: Evaluate(feature, userContext),
+		})
+	}
+	return evaluated
+}
@@ -0,0 +1,70 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuthMiddleware authenticates management API requests with a bearer token
+// and attaches the principal to the request context. The admin token, when
+// set, acts as an organization scoped token so the first tokens can be
+// created. It must wrap individual routes so the projectId path value is
+// available for the project scope check.
+func AuthMiddleware(conn db.Conn, adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || secret == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="modulyn"`)
+				apierror.Error(w, r, "Missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			var principal *models.Principal
+			if adminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(adminToken)) == 1 {
+				principal = &models.Principal{
+					TokenID: models.BootstrapTokenID,
+					Name:    "admin",
+					Scope:   models.TokenScopeOrganization,
+					Role:    models.RoleAdmin,
+				}
+			} else {
+				var err error
+				principal, err = conn.AuthenticateToken(r.Context(), secret)
+				if errors.Is(err, db.ErrNoRows) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="modulyn", error="invalid_token"`)
+					apierror.Error(w, r, "Invalid or expired token", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					log.Println("Error authenticating token:", err)
+					apierror.FromError(w, r, err, "Failed to authenticate")
+					return
+				}
+			}
+
+			if projectID := r.PathValue("projectId"); projectID != "" && !principal.CanAccessProject(projectID) {
+				apierror.Error(w, r, "Token is not scoped to this project", http.StatusForbidden)
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.String("modulyn.principal.token_id", principal.TokenID),
+				attribute.String("modulyn.principal.scope", principal.Scope),
+			)
+
+			ctx := context.WithValue(r.Context(), db.PrincipalKey, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
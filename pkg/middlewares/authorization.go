@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/http"
+)
+
+// Policy describes what a route requires of the caller
+type Policy struct {
+	// Roles maps an HTTP method to the minimum role on the project, methods
+	// that are not listed require the admin role
+	Roles map[string]string
+	// Environments returns the environments a request writes to so the
+	// role required by restricted environments is enforced as well
+	Environments func(r *http.Request) ([]string, error)
+}
+
+// AuthorizationMiddleware enforces a route policy against the principal
+// attached by AuthMiddleware before the controller runs. The role on a
+// project is the higher of the token's own role and any role granted to
+// the token on that project.
+func AuthorizationMiddleware(conn db.Conn) func(policy Policy, next http.Handler) http.Handler {
+	return func(policy Policy, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := db.PrincipalFromContext(r.Context())
+			if !ok {
+				apierror.Error(w, r, "Missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			projectID := r.PathValue("projectId")
+			role := principal.Role
+			if projectID != "" {
+				role = principal.ProjectRole(projectID)
+				if principal.TokenID != models.BootstrapTokenID {
+					grant, err := conn.GetRoleGrant(r.Context(), projectID, principal.TokenID)
+					if err != nil {
+						log.Println("Error getting role grant:", err)
+						apierror.FromError(w, r, err, "Failed to authorize")
+						return
+					}
+					role = models.HigherRole(role, grant)
+				}
+			}
+
+			required, ok := policy.Roles[r.Method]
+			if !ok {
+				required = models.RoleAdmin
+			}
+			if !models.RoleSatisfies(role, required) {
+				apierror.Error(w, r, forbiddenMessage(required, role, projectID), http.StatusForbidden)
+				return
+			}
+
+			if policy.Environments == nil || projectID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			environmentIDs, err := policy.Environments(r)
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				apierror.Error(w, r, fmt.Sprintf("Request body must not exceed %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				log.Println("Error reading environments from request:", err)
+				apierror.Error(w, r, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			for _, environmentID := range environmentIDs {
+				environment, err := conn.GetEnvironment(r.Context(), projectID, environmentID)
+				// unknown environments are reported by request validation
+				if errors.Is(err, db.ErrNoRows) {
+					continue
+				}
+				if err != nil {
+					log.Println("Error getting environment:", err)
+					apierror.FromError(w, r, err, "Failed to authorize")
+					return
+				}
+				if environment.Restricted && !models.RoleSatisfies(role, environment.RequiredRole) {
+					apierror.Error(w, r, fmt.Sprintf("Environment %q is restricted to the %s role, token has %s", environment.Name, environment.RequiredRole, roleOrNone(role)), http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UpdateFeatureEnvironments reads the environments targeted by an
+// UpdateFeatures request body and restores the body for the controller
+func UpdateFeatureEnvironments(r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var updateFeaturesRequest []*models.UpdateFeatureRequest
+	if err := json.Unmarshal(body, &updateFeaturesRequest); err != nil {
+		return nil, err
+	}
+
+	environmentIDs := make([]string, 0, len(updateFeaturesRequest))
+	for _, updateFeatureRequest := range updateFeaturesRequest {
+		// validation reports null entries once the request gets through
+		if updateFeatureRequest == nil {
+			continue
+		}
+		environmentIDs = append(environmentIDs, updateFeatureRequest.EnvironmentID)
+	}
+	return environmentIDs, nil
+}
+
+// PromotionEnvironments reads the target environment of a promotion request
+// body and restores the body for the controller
+func PromotionEnvironments(r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var promotionRequest models.PromotionRequest
+	if err := json.Unmarshal(body, &promotionRequest); err != nil {
+		return nil, err
+	}
+	return []string{promotionRequest.TargetEnvironmentID}, nil
+}
+
+// ChangeRequestEnvironments returns the environment a change request
+// targets so reviewers need the role required by that environment
+func ChangeRequestEnvironments(conn db.Conn) func(r *http.Request) ([]string, error) {
+	return func(r *http.Request) ([]string, error) {
+		changeRequest, err := conn.GetChangeRequest(r.Context(), r.PathValue("projectId"), r.PathValue("changeRequestId"))
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{changeRequest.EnvironmentID}, nil
+	}
+}
+
+func forbiddenMessage(required, role, projectID string) string {
+	if projectID == "" {
+		return fmt.Sprintf("This action requires the %s role, token has %s", required, roleOrNone(role))
+	}
+	return fmt.Sprintf("This action requires the %s role on project %s, token has %s", required, projectID, roleOrNone(role))
+}
+
+func roleOrNone(role string) string {
+	if role == "" {
+		return "no role"
+	}
+	return role
+}
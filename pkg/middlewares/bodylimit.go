@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// defaultMaxBodyBytes bounds request bodies unless MAX_REQUEST_BODY_BYTES
+// is set
+const defaultMaxBodyBytes = 1 << 20
+
+// MaxBodyBytesFromEnv reads the request body limit from
+// MAX_REQUEST_BODY_BYTES
+func MaxBodyBytesFromEnv() int64 {
+	value, ok := os.LookupEnv("MAX_REQUEST_BODY_BYTES")
+	if !ok {
+		return defaultMaxBodyBytes
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		log.Printf("Ignoring invalid MAX_REQUEST_BODY_BYTES %q", value)
+		return defaultMaxBodyBytes
+	}
+	return parsed
+}
+
+// BodyLimitMiddleware caps the size of request bodies, reading past the
+// limit fails with an *http.MaxBytesError
+func BodyLimitMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"log"
+	"modulyn/pkg/apierror"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorsConfig is the cross-origin policy for a group of routes
+type CorsConfig struct {
+	// AllowedOrigins holds exact origins, "*" for any origin or wildcard
+	// subdomains such as "https://*.example.com"
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CorsConfigFromEnv overrides the defaults with the <prefix>_ALLOWED_ORIGINS,
+// <prefix>_ALLOWED_METHODS, <prefix>_ALLOWED_HEADERS, <prefix>_EXPOSED_HEADERS,
+// <prefix>_ALLOW_CREDENTIALS and <prefix>_MAX_AGE environment variables.
+// Lists are comma separated and the max age is a Go duration.
+func CorsConfigFromEnv(prefix string, defaults CorsConfig) CorsConfig {
+	config := defaults
+
+	if value, ok := os.LookupEnv(prefix + "_ALLOWED_ORIGINS"); ok {
+		config.AllowedOrigins = splitList(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "_ALLOWED_METHODS"); ok {
+		config.AllowedMethods = splitList(strings.ToUpper(value))
+	}
+	if value, ok := os.LookupEnv(prefix + "_ALLOWED_HEADERS"); ok {
+		config.AllowedHeaders = splitList(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "_EXPOSED_HEADERS"); ok {
+		config.ExposedHeaders = splitList(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "_ALLOW_CREDENTIALS"); ok {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s_ALLOW_CREDENTIALS %q", prefix, value)
+		} else {
+			config.AllowCredentials = allow
+		}
+	}
+	if value, ok := os.LookupEnv(prefix + "_MAX_AGE"); ok {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s_MAX_AGE %q", prefix, value)
+		} else {
+			config.MaxAge = maxAge
+		}
+	}
+
+	log.Printf("%s policy: origins %v, credentials %t", prefix, config.AllowedOrigins, config.AllowCredentials)
+	return config
+}
+
+// CorsMiddleware applies the policy to every request and answers preflight
+// requests itself, so handlers never see them
+func CorsMiddleware(config CorsConfig) func(http.Handler) http.Handler {
+	allowedMethods := strings.Join(config.AllowedMethods, ", ")
+	allowedHeaders := strings.Join(config.AllowedHeaders, ", ")
+	exposedHeaders := strings.Join(config.ExposedHeaders, ", ")
+	anyHeader := slices.Contains(config.AllowedHeaders, "*")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !config.allowsOrigin(origin) {
+				if preflight {
+					apierror.Error(w, r, "Origin not allowed", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// a literal "*" cannot be combined with credentials
+			if slices.Contains(config.AllowedOrigins, "*") && !config.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if config.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if exposedHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			requestedMethod := r.Header.Get("Access-Control-Request-Method")
+			if !slices.Contains(config.AllowedMethods, requestedMethod) {
+				apierror.Error(w, r, "Method not allowed by CORS policy", http.StatusForbidden)
+				return
+			}
+
+			requestedHeaders := splitList(r.Header.Get("Access-Control-Request-Headers"))
+			if !anyHeader {
+				for _, header := range requestedHeaders {
+					if !slices.ContainsFunc(config.AllowedHeaders, func(allowed string) bool {
+						return strings.EqualFold(allowed, header)
+					}) {
+						apierror.Error(w, r, "Header not allowed by CORS policy", http.StatusForbidden)
+						return
+					}
+				}
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			if anyHeader {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+			} else if allowedHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			}
+			if config.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func (config CorsConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		scheme, pattern, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Scheme, scheme) {
+			continue
+		}
+		// the wildcard covers subdomains only, not the bare domain
+		if strings.HasSuffix(strings.ToLower(u.Host), "."+strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"modulyn/pkg/db"
+	"modulyn/pkg/ratelimit"
+	"net/http"
+)
+
+// IPRateLimitMiddleware limits the request rate of every remote IP
+func IPRateLimitMiddleware(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, retryAfter := limiter.AllowIP(r); !ok {
+				ratelimit.Reject(w, r, retryAfter, "Too many requests from this address")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TokenRateLimitMiddleware limits the request rate of the authenticated API
+// token, using the overrides of the project in the path when there is one.
+// It must run after AuthMiddleware.
+func TokenRateLimitMiddleware(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := db.PrincipalFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok, retryAfter := limiter.AllowAPIToken(r.Context(), r.PathValue("projectId"), principal.TokenID); !ok {
+				ratelimit.Reject(w, r, retryAfter, "Too many requests for this API token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+const (
+	ChangeRequestPending    = "pending"
+	ChangeRequestApplied    = "applied"
+	ChangeRequestRejected   = "rejected"
+	ChangeRequestConflicted = "conflicted"
+)
+
+// ChangeRequest is a proposed update of a feature in an environment that
+// requires approval before it is applied
+type ChangeRequest struct {
+	ID              string               `json:"id"`
+	ProjectID       string               `json:"projectId"`
+	FeatureID       string               `json:"featureId"`
+	EnvironmentID   string               `json:"environmentId"`
+	Proposed        UpdateFeatureRequest `json:"proposed"`
+	Base            FeatureState         `json:"base"`
+	Reason          string               `json:"reason"`
+	Status          string               `json:"status"`
+	AuthorTokenID   string               `json:"authorTokenId"`
+	AuthorName      string               `json:"authorName"`
+	ReviewerTokenID string               `json:"reviewerTokenId,omitempty"`
+	ReviewerName    string               `json:"reviewerName,omitempty"`
+	ReviewComment   string               `json:"reviewComment,omitempty"`
+	CreatedAt       string               `json:"createdAt"`
+	ReviewedAt      string               `json:"reviewedAt,omitempty"`
+	// FromSpec is set on requests proposed by applying a spec, they may
+	// change features managed as code
+	FromSpec bool `json:"fromSpec,omitempty"`
+}
+
+// FeatureState is the per environment state of a feature a change request
+// was based on, used to detect conflicting changes
+type FeatureState struct {
+	Enabled   bool      `json:"enabled"`
+	JsonValue JsonValue `json:"jsonValue"`
+}
+
+type ReviewChangeRequest struct {
+	Comment string `json:"comment"`
+}
+
+// UpdateFeaturesResult reports which updates were applied and which are
+// waiting for approval
+type UpdateFeaturesResult struct {
+	Applied        []string         `json:"applied"`
+	ChangeRequests []*ChangeRequest `json:"changeRequests"`
+}
@@ -1,7 +1,18 @@
 package models
 
 type Client struct {
-	SDKKey   string
-	AppID    string
-	Messages chan Event
+	SDKKey        string
+	SDKKeyID      string
+	EnvironmentID string
+	AppID         string
+	Messages      chan Event
+	// Done is closed when the server drops the client, e.g. after its key is revoked
+	Done chan struct{}
+	// ClientSide clients only receive values evaluated for their UserContext
+	ClientSide  bool
+	UserContext map[string]string
+	// Tags limits the subscription to features carrying the tags, TagMatch
+	// says whether any or all of them. Every feature is sent when it is empty.
+	Tags     []string
+	TagMatch string
 }
@@ -2,13 +2,55 @@ package models
 
 type CreateEnvironmentRequest struct {
 	Name string `json:"name"`
+	// SourceEnvironmentID copies the state, value and targeting of every
+	// feature from that environment, features start disabled without it
+	SourceEnvironmentID string `json:"sourceEnvironmentId,omitempty"`
+}
+
+type CloneEnvironmentRequest struct {
+	Name string `json:"name"`
 }
 
 type UpdateEnvironmentRequest struct {
 	Name string `json:"name"`
+	// RequiredRole restricts feature updates to this role or higher, an
+	// empty string lifts the restriction and nil leaves it unchanged
+	RequiredRole *string `json:"requiredRole"`
+	// RequiresApproval routes feature updates through change requests, nil
+	// leaves it unchanged
+	RequiresApproval *bool `json:"requiresApproval"`
 }
 
 type Environment struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Restricted       bool   `json:"restricted"`
+	RequiredRole     string `json:"requiredRole,omitempty"`
+	RequiresApproval bool   `json:"requiresApproval"`
+}
+
+// EnvironmentComparison lists every feature of a project with its state in
+// two environments
+type EnvironmentComparison struct {
+	BaseEnvironmentID   string               `json:"baseEnvironmentId"`
+	TargetEnvironmentID string               `json:"targetEnvironmentId"`
+	Features            []*FeatureComparison `json:"features"`
+	// Different counts the features that differ in any way
+	Different int `json:"different"`
+}
+
+// FeatureComparison tells which parts of a feature differ between the base
+// and target environment. The state is whether the feature is enabled, the
+// value its targeting values and the targeting its key and whether
+// targeting is enabled.
+type FeatureComparison struct {
+	FeatureID        string       `json:"featureId"`
+	Name             string       `json:"name"`
+	Label            string       `json:"label"`
+	Base             FeatureState `json:"base"`
+	Target           FeatureState `json:"target"`
+	StateDiffers     bool         `json:"stateDiffers"`
+	ValueDiffers     bool         `json:"valueDiffers"`
+	TargetingDiffers bool         `json:"targetingDiffers"`
+	Differs          bool         `json:"differs"`
 }
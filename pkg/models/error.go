@@ -0,0 +1,22 @@
+package models
+
+// ErrorResponse is the body of every error returned by the API
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	// Code is a machine readable identifier of the error
+	Code          string       `json:"code"`
+	Message       string       `json:"message"`
+	CorrelationID string       `json:"correlationId,omitempty"`
+	Details       []FieldError `json:"details,omitempty"`
+	// Current is the current state of a resource a precondition failed on
+	Current any `json:"current,omitempty"`
+}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,88 @@
+package models
+
+// ProjectExportVersion is the version of the export document written by
+// this installation, import accepts it and every earlier version
+const ProjectExportVersion = 1
+
+const (
+	ImportStrategySkip      = "skip"
+	ImportStrategyOverwrite = "overwrite"
+	ImportStrategyFail      = "fail"
+)
+
+var ImportStrategies = []string{ImportStrategySkip, ImportStrategyOverwrite, ImportStrategyFail}
+
+const (
+	ImportActionCreated = "created"
+	ImportActionUpdated = "updated"
+	ImportActionSkipped = "skipped"
+)
+
+// ProjectExport is a portable copy of a project's environments and
+// features. IDs are those of the exporting installation, import maps them
+// to new or existing ones.
+type ProjectExport struct {
+	Version      int                    `json:"version"`
+	ExportedAt   string                 `json:"exportedAt"`
+	Project      ExportedProject        `json:"project"`
+	Environments []*ExportedEnvironment `json:"environments"`
+	Features     []*ExportedFeature     `json:"features"`
+}
+
+type ExportedProject struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ExportedEnvironment struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	RequiredRole     string `json:"requiredRole,omitempty"`
+	RequiresApproval bool   `json:"requiresApproval"`
+}
+
+type ExportedFeature struct {
+	ID                  string            `json:"id"`
+	Key                 string            `json:"key"`
+	Name                string            `json:"name"`
+	Description         string            `json:"description"`
+	ClientSideAvailable bool              `json:"clientSideAvailable"`
+	Tags                []string          `json:"tags"`
+	Owner               string            `json:"owner"`
+	Maintainer          string            `json:"maintainer"`
+	Type                string            `json:"type"`
+	Properties          map[string]string `json:"properties"`
+	Links               []Link            `json:"links"`
+	// States holds the state, value and targeting per exported environment
+	// ID, environments without an entry get the feature disabled
+	States map[string]FeatureState `json:"states"`
+}
+
+// ImportOptions controls how an export document is imported. Environments
+// are matched by name and features by key, the strategy decides what
+// happens to matches: skip keeps them, overwrite replaces them with the
+// document and fail rejects the import if any feature already exists.
+type ImportOptions struct {
+	Strategy string
+	// Name renames the project when the import creates a new one
+	Name string
+}
+
+// ImportReport maps every imported environment and feature to its ID in
+// this installation and tells what the import did with it
+type ImportReport struct {
+	ProjectID    string              `json:"projectId"`
+	Strategy     string              `json:"strategy"`
+	Environments []*ImportedResource `json:"environments"`
+	Features     []*ImportedResource `json:"features"`
+	// ChangeRequests propose the imported states for environments that
+	// require approval, they are not applied by the import
+	ChangeRequests []*ChangeRequest `json:"changeRequests,omitempty"`
+}
+
+type ImportedResource struct {
+	SourceID string `json:"sourceId"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+}
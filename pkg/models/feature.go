@@ -1,30 +1,125 @@
 package models
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	FeatureTypeRelease     = "release"
+	FeatureTypeExperiment  = "experiment"
+	FeatureTypeOperational = "operational"
+	FeatureTypeKillSwitch  = "kill-switch"
+	FeatureTypePermission  = "permission"
+)
+
+// FeatureTypes are the valid feature types, release is the default
+var FeatureTypes = []string{FeatureTypeRelease, FeatureTypeExperiment, FeatureTypeOperational, FeatureTypeKillSwitch, FeatureTypePermission}
+
+// Feature is the state of a feature in one environment. Its Label is the
+// key SDKs evaluate it by, unique within a project and immutable.
 type Feature struct {
-	ID              string    `json:"id"`
-	Name            string    `json:"name"`
-	Label           string    `json:"label"`
-	Description     string    `json:"description"`
-	Enabled         bool      `json:"enabled"`
-	JsonValue       JsonValue `json:"jsonValue"`
-	CreatedAt       string    `json:"createdAt"`
-	UpdatedAt       string    `json:"updatedAt"`
-	DeletedAt       string    `json:"deletedAt"`
-	EnvironmentID   string    `json:"environmentId"`
-	EnvironmentName string    `json:"environmentName"`
-	ProjectID       string    `json:"projectId"`
-	ProjectName     string    `json:"projectName"`
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Label               string    `json:"label"`
+	Description         string    `json:"description"`
+	Enabled             bool      `json:"enabled"`
+	JsonValue           JsonValue `json:"jsonValue"`
+	ClientSideAvailable bool      `json:"clientSideAvailable"`
+	CreatedAt           string    `json:"createdAt"`
+	UpdatedAt           string    `json:"updatedAt"`
+	DeletedAt           string    `json:"deletedAt"`
+	EnvironmentID       string    `json:"environmentId"`
+	EnvironmentName     string    `json:"environmentName"`
+	ProjectID           string    `json:"projectId"`
+	ProjectName         string    `json:"projectName"`
+	Tags                []string  `json:"tags"`
+	// Owner is the user or team accountable for the feature
+	Owner string `json:"owner"`
+	// Maintainer is how to reach whoever maintains the feature, such as an
+	// email address or a chat channel
+	Maintainer string `json:"maintainer"`
+	// Type says what the feature is for, one of FeatureTypes
+	Type string `json:"type"`
+	// Properties are free-form values such as a cost center or a review date
+	Properties map[string]string `json:"properties"`
+	Links      []Link            `json:"links"`
+	Managed    bool              `json:"managed"`
+	// Version increases with every change to the feature in this
+	// environment
+	Version int `json:"version"`
+}
+
+// FeatureETag is the entity tag of a feature across its environments, it
+// changes whenever the version of one of its rows does
+func FeatureETag(features []*Feature) string {
+	versions := make([]string, 0, len(features))
+	for _, feature := range features {
+		versions = append(versions, fmt.Sprintf("%s:%s:%d", feature.ID, feature.EnvironmentID, feature.Version))
+	}
+	slices.Sort(versions)
+
+	sum := sha256.Sum256([]byte(strings.Join(versions, "\n")))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Link points from a feature to related documents such as issues or specs
+type Link struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url"`
 }
 
 type CreateFeatureRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	ClientSideAvailable bool   `json:"clientSideAvailable"`
+	// Key becomes the feature's label, it is derived from the name when empty
+	Key        string            `json:"key,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Maintainer string            `json:"maintainer,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Links      []Link            `json:"links,omitempty"`
+}
+
+// UpdateFeatureMetadataRequest changes the fields a feature shares across
+// its environments, omitted fields keep their value and an empty list or
+// object clears tags, links or properties. The key cannot be changed.
+type UpdateFeatureMetadataRequest struct {
+	Name        *string           `json:"name,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Owner       *string           `json:"owner,omitempty"`
+	Maintainer  *string           `json:"maintainer,omitempty"`
+	Type        *string           `json:"type,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	Links       []Link            `json:"links,omitempty"`
+}
+
+type UpdateFeatureClientSideRequest struct {
+	ClientSideAvailable bool `json:"clientSideAvailable"`
+}
+
+// EvaluatedFeature is the only view of a feature client-side SDKs receive
+type EvaluatedFeature struct {
+	Key   string `json:"key"`
+	Value bool   `json:"value"`
 }
 
 type UpdateFeatureRequest struct {
 	EnvironmentID string    `json:"environmentId"`
 	Enabled       bool      `json:"enabled"`
 	JsonValue     JsonValue `json:"jsonValue,omitempty"`
+	// Reason is recorded on the change request when the environment requires approval
+	Reason string `json:"reason,omitempty"`
+	// Version is the version of the feature in the environment the update
+	// was made against, it is required unless the request has an If-Match
+	// header
+	Version *int `json:"version,omitempty"`
 }
 
 type JsonValue struct {
@@ -0,0 +1,49 @@
+package models
+
+// RateLimit is a token bucket refilled at Rate requests per second, a
+// non-positive rate disables the limit
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Limits holds rate limits and connection quotas, nil fields fall back to
+// the global configuration and a zero connection quota means unlimited
+type Limits struct {
+	APIToken                     *RateLimit `json:"apiToken,omitempty"`
+	SDKKey                       *RateLimit `json:"sdkKey,omitempty"`
+	MaxConnectionsPerEnvironment *int       `json:"maxConnectionsPerEnvironment,omitempty"`
+	MaxConnectionsPerApp         *int       `json:"maxConnectionsPerApp,omitempty"`
+}
+
+// Merge returns the limits with every field set in overrides replaced
+func (l Limits) Merge(overrides *Limits) Limits {
+	if overrides == nil {
+		return l
+	}
+	if overrides.APIToken != nil {
+		l.APIToken = overrides.APIToken
+	}
+	if overrides.SDKKey != nil {
+		l.SDKKey = overrides.SDKKey
+	}
+	if overrides.MaxConnectionsPerEnvironment != nil {
+		l.MaxConnectionsPerEnvironment = overrides.MaxConnectionsPerEnvironment
+	}
+	if overrides.MaxConnectionsPerApp != nil {
+		l.MaxConnectionsPerApp = overrides.MaxConnectionsPerApp
+	}
+	return l
+}
+
+type ProjectLimits struct {
+	ProjectID string `json:"projectId"`
+	Overrides Limits `json:"overrides"`
+	Effective Limits `json:"effective"`
+}
+
+// ConnectionQuota caps the concurrent event stream connections of a client
+type ConnectionQuota struct {
+	MaxPerEnvironment int
+	MaxPerApp         int
+}
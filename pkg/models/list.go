@@ -0,0 +1,41 @@
+package models
+
+const (
+	SortName    = "name"
+	SortCreated = "created"
+	SortUpdated = "updated"
+)
+
+// ListOptions selects one page of a list endpoint
+type ListOptions struct {
+	// Limit is the page size, zero returns every row
+	Limit int
+	// Cursor is the Next value returned with the previous page
+	Cursor     string
+	Sort       string
+	Descending bool
+}
+
+type FeatureFilter struct {
+	ListOptions
+	Search        string
+	EnvironmentID string
+	Enabled       *bool
+	// UpdatedSince is an RFC 3339 timestamp
+	UpdatedSince string
+	// Tags keeps the features carrying the tags, TagMatch says whether any
+	// or all of them
+	Tags       []string
+	TagMatch   string
+	Owner      string
+	Maintainer string
+	Type       string
+	// Properties keeps the features having every one of the values
+	Properties map[string]string
+}
+
+type ProjectFilter struct {
+	ListOptions
+	// ProjectIDs restricts the list to the given projects when it is not nil
+	ProjectIDs []string
+}
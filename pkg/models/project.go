@@ -9,6 +9,26 @@ type UpdateProjectRequest struct {
 }
 
 type Project struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// ProjectDetails is a project with a summary of its environments and
+// features
+type ProjectDetails struct {
+	Project
+	Environments  []*EnvironmentSummary `json:"environments"`
+	FeatureCount  int                   `json:"featureCount"`
+	ConnectedSDKs int                   `json:"connectedSdks"`
+	// LastChangeAt is the last time the project, one of its environments or
+	// one of its features was changed
+	LastChangeAt string `json:"lastChangeAt"`
+}
+
+type EnvironmentSummary struct {
+	Environment
+	EnabledFeatures int `json:"enabledFeatures"`
+	ConnectedSDKs   int `json:"connectedSdks"`
 }
@@ -0,0 +1,37 @@
+package models
+
+// PromotionRequest copies the state, value and targeting of features from
+// one environment to another
+type PromotionRequest struct {
+	SourceEnvironmentID string `json:"sourceEnvironmentId"`
+	TargetEnvironmentID string `json:"targetEnvironmentId"`
+	// FeatureIDs limits the promotion to these features, all features are
+	// promoted when it is empty
+	FeatureIDs []string `json:"featureIds,omitempty"`
+	// Reason is recorded on the change requests when the target environment
+	// requires approval
+	Reason string `json:"reason,omitempty"`
+}
+
+// Promotion is the difference between the source and target environment
+// for the selected features. Features already in the same state are not
+// listed.
+type Promotion struct {
+	SourceEnvironmentID string             `json:"sourceEnvironmentId"`
+	TargetEnvironmentID string             `json:"targetEnvironmentId"`
+	Changes             []*PromotionChange `json:"changes"`
+	Unchanged           int                `json:"unchanged"`
+	// Applied is set once the changes were written to the target environment
+	Applied        bool             `json:"applied"`
+	ChangeRequests []*ChangeRequest `json:"changeRequests,omitempty"`
+}
+
+type PromotionChange struct {
+	FeatureID string       `json:"featureId"`
+	Name      string       `json:"name"`
+	Label     string       `json:"label"`
+	Current   FeatureState `json:"current"`
+	Promoted  FeatureState `json:"promoted"`
+	// Managed features are owned by a flags-as-code spec and block applying
+	Managed bool `json:"managed,omitempty"`
+}
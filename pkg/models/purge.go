@@ -0,0 +1,18 @@
+package models
+
+type PurgeRequest struct {
+	// DryRun reports what would be removed without removing anything
+	DryRun bool `json:"dryRun"`
+	// RetentionDays overrides the configured retention period
+	RetentionDays *int `json:"retentionDays,omitempty"`
+}
+
+// PurgeReport lists the projects, environments and features removed for
+// good because they were deleted before DeletedBefore
+type PurgeReport struct {
+	DryRun        bool               `json:"dryRun"`
+	DeletedBefore string             `json:"deletedBefore"`
+	Projects      []*DeletedResource `json:"projects"`
+	Environments  []*DeletedResource `json:"environments"`
+	Features      []*DeletedResource `json:"features"`
+}
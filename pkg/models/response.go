@@ -2,4 +2,7 @@ package models
 
 type Response struct {
 	Data any `json:"data"`
+	// Next is the cursor of the following page of a list, empty on the
+	// last page
+	Next string `json:"next,omitempty"`
 }
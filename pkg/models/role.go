@@ -0,0 +1,44 @@
+package models
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleSatisfies reports whether role grants at least the permissions of required
+func RoleSatisfies(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// HigherRole returns the more privileged of the two roles
+func HigherRole(a, b string) string {
+	if roleRanks[a] >= roleRanks[b] {
+		return a
+	}
+	return b
+}
+
+type GrantRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type RoleGrant struct {
+	TokenID   string `json:"tokenId"`
+	TokenName string `json:"tokenName"`
+	ProjectID string `json:"projectId"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"createdAt"`
+}
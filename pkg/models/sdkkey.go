@@ -0,0 +1,26 @@
+package models
+
+const (
+	SDKKeyKindServer = "server"
+	SDKKeyKindClient = "client"
+)
+
+type RotateSDKKeyRequest struct {
+	// Kind selects the server or client key to rotate, server by default
+	Kind string `json:"kind"`
+	// GracePeriod is a Go duration such as "24h" during which the previous
+	// keys keep working alongside the new one
+	GracePeriod string `json:"gracePeriod"`
+}
+
+type SDKKey struct {
+	ID            string `json:"id"`
+	Key           string `json:"key"`
+	Kind          string `json:"kind"`
+	EnvironmentID string `json:"environmentId"`
+	ProjectID     string `json:"projectId"`
+	Active        bool   `json:"active"`
+	CreatedAt     string `json:"createdAt"`
+	ExpiresAt     string `json:"expiresAt,omitempty"`
+	RevokedAt     string `json:"revokedAt,omitempty"`
+}
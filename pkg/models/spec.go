@@ -0,0 +1,83 @@
+package models
+
+const (
+	SpecActionCreate = "create"
+	SpecActionUpdate = "update"
+	SpecActionDelete = "delete"
+)
+
+const (
+	SpecResourceEnvironment  = "environment"
+	SpecResourceFeature      = "feature"
+	SpecResourceFeatureState = "feature_state"
+)
+
+// FlagSpec declares the environments and features a project should have.
+// Features list their state per environment name, environments they leave
+// out have the feature disabled.
+type FlagSpec struct {
+	Environments []*EnvironmentSpec `json:"environments" yaml:"environments"`
+	Features     []*FeatureSpec     `json:"features" yaml:"features"`
+	// Prune deletes environments and unmanaged features missing from the
+	// spec, otherwise only managed features missing from it are deleted
+	Prune bool `json:"prune,omitempty" yaml:"prune,omitempty"`
+}
+
+type EnvironmentSpec struct {
+	Name             string `json:"name" yaml:"name"`
+	RequiredRole     string `json:"requiredRole,omitempty" yaml:"requiredRole,omitempty"`
+	RequiresApproval bool   `json:"requiresApproval,omitempty" yaml:"requiresApproval,omitempty"`
+}
+
+type FeatureSpec struct {
+	Key                 string   `json:"key" yaml:"key"`
+	Name                string   `json:"name" yaml:"name"`
+	Description         string   `json:"description,omitempty" yaml:"description,omitempty"`
+	ClientSideAvailable bool     `json:"clientSideAvailable,omitempty" yaml:"clientSideAvailable,omitempty"`
+	Tags                []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Owner               string   `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Maintainer          string   `json:"maintainer,omitempty" yaml:"maintainer,omitempty"`
+	Type                string   `json:"type,omitempty" yaml:"type,omitempty"`
+	Links               []Link   `json:"links,omitempty" yaml:"links,omitempty"`
+	// Properties replace the custom properties of the feature
+	Properties map[string]string `json:"properties,omitempty" yaml:"properties,omitempty"`
+	// Managed rejects changes to the feature outside of applying a spec
+	Managed      bool                         `json:"managed,omitempty" yaml:"managed,omitempty"`
+	Environments map[string]*FeatureStateSpec `json:"environments,omitempty" yaml:"environments,omitempty"`
+}
+
+type FeatureStateSpec struct {
+	Enabled   bool      `json:"enabled" yaml:"enabled"`
+	Targeting JsonValue `json:"targeting" yaml:"targeting"`
+}
+
+// SpecPlan lists the changes that bring a project in line with a spec, in
+// the order they are applied
+type SpecPlan struct {
+	Changes []*SpecChange `json:"changes"`
+	// Fingerprint identifies the changes, applying a spec requires the
+	// fingerprint of the plan that was reviewed
+	Fingerprint string `json:"fingerprint"`
+	// Applied is set once the changes were written
+	Applied bool `json:"applied"`
+	// ChangeRequests propose the states in environments that require
+	// approval, they are not applied with the rest of the plan
+	ChangeRequests []*ChangeRequest `json:"changeRequests,omitempty"`
+}
+
+// SpecChange creates, updates or deletes an environment, a feature or the
+// state of a feature in one environment. Key is the environment name or
+// feature key, ID the live environment or feature the change applies to
+// and Fields lists what an update changes.
+type SpecChange struct {
+	Action      string   `json:"action"`
+	Resource    string   `json:"resource"`
+	Key         string   `json:"key"`
+	ID          string   `json:"id,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+	Fields      []string `json:"fields,omitempty"`
+
+	EnvironmentSpec *EnvironmentSpec  `json:"-"`
+	FeatureSpec     *FeatureSpec      `json:"-"`
+	State           *FeatureStateSpec `json:"-"`
+}
@@ -0,0 +1,21 @@
+package models
+
+const (
+	// TagMatchAny keeps the features carrying at least one of the tags
+	TagMatchAny = "any"
+	// TagMatchAll keeps the features carrying every one of the tags
+	TagMatchAll = "all"
+)
+
+// Tag is a label features are grouped by, with the number of active
+// features carrying it
+type Tag struct {
+	Name     string `json:"name"`
+	Features int    `json:"features"`
+}
+
+// RenameTagRequest renames a tag on every feature carrying it, features
+// that already carry the new name keep it once
+type RenameTagRequest struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,58 @@
+package models
+
+const (
+	TokenScopeOrganization = "organization"
+	TokenScopeProject      = "project"
+)
+
+// BootstrapTokenID identifies the admin token from the environment, it is
+// shared by everyone who knows it so it does not identify a person
+const BootstrapTokenID = "bootstrap"
+
+type CreateTokenRequest struct {
+	Name      string `json:"name"`
+	ProjectID string `json:"projectId"`
+	Role      string `json:"role"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+type Token struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	Scope      string `json:"scope"`
+	ProjectID  string `json:"projectId,omitempty"`
+	Role       string `json:"role"`
+	CreatedAt  string `json:"createdAt"`
+	ExpiresAt  string `json:"expiresAt,omitempty"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+// CreatedToken is only returned once, the secret is not stored in plain text
+type CreatedToken struct {
+	Token
+	Secret string `json:"token"`
+}
+
+// Principal is the authenticated caller of the management API
+type Principal struct {
+	TokenID   string `json:"tokenId"`
+	Name      string `json:"name"`
+	Scope     string `json:"scope"`
+	ProjectID string `json:"projectId,omitempty"`
+	Role      string `json:"role"`
+}
+
+// ProjectRole returns the role the token itself carries for the project,
+// grants made per project are resolved separately by the authorizer
+func (p *Principal) ProjectRole(projectID string) string {
+	if !p.CanAccessProject(projectID) {
+		return ""
+	}
+	return p.Role
+}
+
+// CanAccessProject reports whether the principal is allowed to act on the project
+func (p *Principal) CanAccessProject(projectID string) bool {
+	return p.Scope == TokenScopeOrganization || p.ProjectID == projectID
+}
@@ -0,0 +1,16 @@
+package models
+
+// Trash lists the environments and features of a project that were deleted
+// directly and can be restored. Rows deleted along with their environment
+// or project come back when that is restored instead.
+type Trash struct {
+	Environments []*DeletedResource `json:"environments"`
+	Features     []*DeletedResource `json:"features"`
+}
+
+type DeletedResource struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Label     string `json:"label,omitempty"`
+	DeletedAt string `json:"deletedAt"`
+}
@@ -0,0 +1,92 @@
+package models
+
+import "encoding/json"
+
+const (
+	WebhookEventFeatureCreated         = "feature_created"
+	WebhookEventFeatureUpdated         = "feature_updated"
+	WebhookEventFeatureDeleted         = "feature_deleted"
+	WebhookEventFeatureMetadataUpdated = "feature_metadata_updated"
+	WebhookEventEnvironmentCreated     = "environment_created"
+	WebhookEventEnvironmentUpdated     = "environment_updated"
+	WebhookEventEnvironmentDeleted     = "environment_deleted"
+	WebhookEventTest                   = "test"
+)
+
+// WebhookEventTypes are the event types a webhook can subscribe to
+var WebhookEventTypes = []string{
+	WebhookEventFeatureCreated,
+	WebhookEventFeatureUpdated,
+	WebhookEventFeatureDeleted,
+	WebhookEventFeatureMetadataUpdated,
+	WebhookEventEnvironmentCreated,
+	WebhookEventEnvironmentUpdated,
+	WebhookEventEnvironmentDeleted,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+type CreateWebhookRequest struct {
+	URL string `json:"url"`
+	// EventTypes filters the events sent to the webhook, empty subscribes
+	// to every event type
+	EventTypes []string `json:"eventTypes"`
+	// Secret signs the deliveries, one is generated when it is empty
+	Secret string `json:"secret"`
+}
+
+type UpdateWebhookRequest struct {
+	URL        *string  `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	Active     *bool    `json:"active"`
+}
+
+type Webhook struct {
+	ID         string   `json:"id"`
+	ProjectID  string   `json:"projectId"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	Active     bool     `json:"active"`
+	CreatedAt  string   `json:"createdAt"`
+	UpdatedAt  string   `json:"updatedAt"`
+}
+
+// CreatedWebhook is returned when a webhook is created, the secret is not
+// returned afterwards
+type CreatedWebhook struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookPayload is the JSON body posted to a webhook
+type WebhookPayload struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	ProjectID     string `json:"projectId"`
+	EnvironmentID string `json:"environmentId,omitempty"`
+	CreatedAt     string `json:"createdAt"`
+	Data          any    `json:"data"`
+}
+
+// WebhookDelivery is one event queued for a webhook and the outcome of
+// the latest attempt to deliver it
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhookId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      string          `json:"createdAt"`
+	NextAttemptAt  string          `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    string          `json:"deliveredAt,omitempty"`
+	// URL and Secret of the webhook, only loaded for the dispatcher
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
@@ -0,0 +1,258 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"modulyn/pkg/apierror"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// overridesTTL bounds how long a project override is cached
+	overridesTTL = 30 * time.Second
+	// idleBucketTTL is how long an unused bucket is kept before it is swept
+	idleBucketTTL = 10 * time.Minute
+)
+
+type Config struct {
+	// Limits are the global limits, every field is set
+	Limits models.Limits
+	IP     models.RateLimit
+	// TrustForwardedFor uses the first X-Forwarded-For address as the remote
+	// IP, only enable it behind a proxy that sets the header
+	TrustForwardedFor bool
+}
+
+// ConfigFromEnv reads the global limits from the RATE_LIMIT_* and
+// MAX_CONNECTIONS_* environment variables
+func ConfigFromEnv() Config {
+	return Config{
+		Limits: models.Limits{
+			APIToken: &models.RateLimit{
+				Rate:  envFloat("RATE_LIMIT_API_TOKEN_RATE", 20),
+				Burst: envInt("RATE_LIMIT_API_TOKEN_BURST", 40),
+			},
+			SDKKey: &models.RateLimit{
+				Rate:  envFloat("RATE_LIMIT_SDK_KEY_RATE", 5),
+				Burst: envInt("RATE_LIMIT_SDK_KEY_BURST", 20),
+			},
+			MaxConnectionsPerEnvironment: ptr(envInt("MAX_CONNECTIONS_PER_ENVIRONMENT", 0)),
+			MaxConnectionsPerApp:         ptr(envInt("MAX_CONNECTIONS_PER_APP", 100)),
+		},
+		IP: models.RateLimit{
+			Rate:  envFloat("RATE_LIMIT_IP_RATE", 50),
+			Burst: envInt("RATE_LIMIT_IP_BURST", 100),
+		},
+		TrustForwardedFor: os.Getenv("RATE_LIMIT_TRUST_FORWARDED_FOR") == "true",
+	}
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type cachedLimits struct {
+	limits    models.Limits
+	expiresAt time.Time
+}
+
+// Limiter keeps one token bucket per API token, SDK key and remote IP
+type Limiter struct {
+	config Config
+	conn   db.LimitsDB
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	overrides map[string]cachedLimits
+	lastSweep time.Time
+}
+
+func New(conn db.LimitsDB, config Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		conn:      conn,
+		buckets:   make(map[string]*bucket),
+		overrides: make(map[string]cachedLimits),
+		lastSweep: time.Now(),
+	}
+}
+
+// Defaults returns the global limits
+func (l *Limiter) Defaults() models.Limits {
+	return l.config.Limits
+}
+
+// Limits returns the effective limits for a project, the global limits
+// when projectID is empty or the overrides cannot be loaded
+func (l *Limiter) Limits(ctx context.Context, projectID string) models.Limits {
+	if projectID == "" {
+		return l.config.Limits
+	}
+
+	l.mu.Lock()
+	cached, ok := l.overrides[projectID]
+	l.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.limits
+	}
+
+	overrides, err := l.conn.GetProjectLimits(ctx, projectID)
+	if err != nil {
+		log.Println("Error getting project limits, using global limits:", err)
+		return l.config.Limits
+	}
+
+	limits := l.config.Limits.Merge(overrides)
+	l.mu.Lock()
+	l.overrides[projectID] = cachedLimits{
+		limits:    limits,
+		expiresAt: time.Now().Add(overridesTTL),
+	}
+	l.mu.Unlock()
+	return limits
+}
+
+// Invalidate drops the cached limits of a project after its overrides change
+func (l *Limiter) Invalidate(projectID string) {
+	l.mu.Lock()
+	delete(l.overrides, projectID)
+	l.mu.Unlock()
+}
+
+// Allow takes a token from the bucket identified by key. When the bucket is
+// empty it returns false and how long the caller should wait.
+func (l *Limiter) Allow(key string, limit models.RateLimit) (bool, time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	// pick up overrides changed since the bucket was created
+	if b.limiter.Limit() != rate.Limit(limit.Rate) {
+		b.limiter.SetLimitAt(now, rate.Limit(limit.Rate))
+	}
+	if b.limiter.Burst() != max(limit.Burst, 1) {
+		b.limiter.SetBurstAt(now, max(limit.Burst, 1))
+	}
+	l.mu.Unlock()
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// AllowIP applies the per remote IP limit to the request
+func (l *Limiter) AllowIP(r *http.Request) (bool, time.Duration) {
+	return l.Allow("ip:"+l.remoteIP(r), l.config.IP)
+}
+
+// AllowAPIToken applies the API token limit of the project to the token.
+// Organization tokens reach several projects, so the bucket is per project
+// and a project override never changes the rate of another project.
+func (l *Limiter) AllowAPIToken(ctx context.Context, projectID, tokenID string) (bool, time.Duration) {
+	return l.Allow("token:"+projectID+":"+tokenID, *l.Limits(ctx, projectID).APIToken)
+}
+
+// AllowSDKKey applies the SDK key limit of the project to the key
+func (l *Limiter) AllowSDKKey(ctx context.Context, projectID, keyID string) (bool, time.Duration) {
+	return l.Allow("sdk:"+keyID, *l.Limits(ctx, projectID).SDKKey)
+}
+
+// ConnectionQuota returns the event stream connection caps of the project
+func (l *Limiter) ConnectionQuota(ctx context.Context, projectID string) models.ConnectionQuota {
+	limits := l.Limits(ctx, projectID)
+	return models.ConnectionQuota{
+		MaxPerEnvironment: *limits.MaxConnectionsPerEnvironment,
+		MaxPerApp:         *limits.MaxConnectionsPerApp,
+	}
+}
+
+// sweep drops idle buckets once a minute, the caller holds the lock
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) remoteIP(r *http.Request) string {
+	if l.config.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Reject writes a 429 response telling the client when to retry
+func Reject(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	apierror.Error(w, r, message, http.StatusTooManyRequests)
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return parsed
+}
+
+func envInt(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return parsed
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
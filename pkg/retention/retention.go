@@ -0,0 +1,99 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	// Retention is how long deleted projects, environments and features
+	// stay in the trash before they are purged
+	Retention time.Duration
+	// Interval is how often the background purge runs
+	Interval time.Duration
+	// DryRun makes the background purge only log what it would remove
+	DryRun bool
+}
+
+// ConfigFromEnv reads the purge settings from TRASH_RETENTION_DAYS,
+// PURGE_INTERVAL_MINUTES and PURGE_DRY_RUN
+func ConfigFromEnv() Config {
+	return Config{
+		Retention: time.Duration(envInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		Interval:  time.Duration(envInt("PURGE_INTERVAL_MINUTES", 60)) * time.Minute,
+		DryRun:    os.Getenv("PURGE_DRY_RUN") == "true",
+	}
+}
+
+// Purger permanently removes soft deleted data once it is older than the
+// retention period
+type Purger struct {
+	conn   db.PurgeDB
+	config Config
+}
+
+func New(conn db.PurgeDB, config Config) *Purger {
+	return &Purger{
+		conn:   conn,
+		config: config,
+	}
+}
+
+// Retention returns the configured retention period
+func (p *Purger) Retention() time.Duration {
+	return p.config.Retention
+}
+
+// Purge removes what was deleted longer than retention ago, or only
+// reports it on a dry run
+func (p *Purger) Purge(ctx context.Context, retention time.Duration, dryRun bool) (*models.PurgeReport, error) {
+	report, err := p.conn.PurgeDeleted(ctx, time.Now().Add(-retention), dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	verb := "Purged"
+	if dryRun {
+		verb = "Would purge"
+	}
+	if len(report.Projects)+len(report.Environments)+len(report.Features) > 0 {
+		log.Printf("%s %d projects, %d environments and %d features deleted before %s", verb, len(report.Projects), len(report.Environments), len(report.Features), report.DeletedBefore)
+	}
+	return report, nil
+}
+
+// Run purges on every interval until the context is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Purge(ctx, p.config.Retention, p.config.DryRun); err != nil {
+			log.Println("Error purging deleted data:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return parsed
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"modulyn/pkg/evaluation"
+	"modulyn/pkg/models"
+	"sync"
+)
+
+// clientSideView tracks the feature keys a client-side subscriber has been
+// sent, so a feature that stops being available can be withdrawn without
+// ever revealing server-only features
+type clientSideView struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newClientSideView() *clientSideView {
+	return &clientSideView{
+		keys: make(map[string]struct{}),
+	}
+}
+
+func (v *clientSideView) snapshot(client *models.Client, features []*models.Feature) models.Event {
+	evaluated := evaluation.EvaluateAll(features, client.UserContext)
+
+	v.mu.Lock()
+	for _, feature := range evaluated {
+		v.keys[feature.Key] = struct{}{}
+	}
+	v.mu.Unlock()
+
+	data, _ := json.Marshal(evaluated)
+	return models.Event{
+		Type: "all_values",
+		Data: data,
+	}
+}
+
+// translate turns a feature event into the event a client-side subscriber
+// is allowed to see, if any
+func (v *clientSideView) translate(client *models.Client, event models.Event) (models.Event, bool) {
+	var feature models.Feature
+	if err := json.Unmarshal(event.Data, &feature); err != nil {
+		return models.Event{}, false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, visible := v.keys[feature.Label]
+	if event.Type == "feature_deleted" || !feature.ClientSideAvailable {
+		if !visible {
+			return models.Event{}, false
+		}
+		delete(v.keys, feature.Label)
+		data, _ := json.Marshal(map[string]string{"key": feature.Label})
+		return models.Event{
+			Type: "value_deleted",
+			Data: data,
+		}, true
+	}
+
+	v.keys[feature.Label] = struct{}{}
+	data, _ := json.Marshal(models.EvaluatedFeature{
+		Key:   feature.Label,
+		Value: evaluation.Evaluate(&feature, client.UserContext),
+	})
+	return models.Event{
+		Type: "value_updated",
+		Data: data,
+	}, true
+}
@@ -1,46 +1,176 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"modulyn/pkg/models"
+	"modulyn/pkg/telemetry"
 	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type store struct {
 	mu      sync.RWMutex
-	clients map[models.Client]struct{}
+	clients map[*models.Client]*subscription
+}
+
+// subscription holds the views narrowing what a subscriber receives
+type subscription struct {
+	// clientSide is nil for server SDKs
+	clientSide *clientSideView
+	// tags is nil when the subscriber receives every feature
+	tags *tagView
 }
 
+var (
+	ErrEnvironmentConnectionLimit = errors.New("too many connections for this environment")
+	ErrAppConnectionLimit         = errors.New("too many connections for this app")
+)
+
 type Store interface {
-	Subscribe(client models.Client)
-	Unsubscribe(client models.Client)
-	NotifyClients(event models.Event, environmentID string)
+	Subscribe(client *models.Client, quota models.ConnectionQuota) error
+	Unsubscribe(client *models.Client)
+	Snapshot(client *models.Client, features []*models.Feature) models.Event
+	NotifyClients(ctx context.Context, event models.Event, environmentID string)
+	DisconnectKey(keyID string) int
+	ConnectedClients(environmentID string) int
 }
 
 func NewStore() Store {
 	return &store{
 		mu:      sync.RWMutex{},
-		clients: make(map[models.Client]struct{}),
+		clients: make(map[*models.Client]*subscription),
 	}
 }
 
-func (s *store) Subscribe(client models.Client) {
+// Subscribe registers the client unless the environment or the app already
+// holds as many connections as the quota allows, zero meaning unlimited
+func (s *store) Subscribe(client *models.Client, quota models.ConnectionQuota) error {
+	sub := &subscription{}
+	if client.ClientSide {
+		sub.clientSide = newClientSideView()
+	}
+	if len(client.Tags) > 0 {
+		sub.tags = newTagView(client.Tags, client.TagMatch)
+	}
+
 	s.mu.Lock()
-	s.clients[client] = struct{}{}
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+
+	environmentConnections, appConnections := 0, 0
+	for existing := range s.clients {
+		if existing.EnvironmentID != client.EnvironmentID {
+			continue
+		}
+		environmentConnections++
+		if existing.AppID == client.AppID {
+			appConnections++
+		}
+	}
+	if quota.MaxPerEnvironment > 0 && environmentConnections >= quota.MaxPerEnvironment {
+		return ErrEnvironmentConnectionLimit
+	}
+	if quota.MaxPerApp > 0 && appConnections >= quota.MaxPerApp {
+		return ErrAppConnectionLimit
+	}
+
+	s.clients[client] = sub
+	return nil
 }
 
-func (s *store) Unsubscribe(client models.Client) {
+func (s *store) Unsubscribe(client *models.Client) {
 	s.mu.Lock()
 	delete(s.clients, client)
 	s.mu.Unlock()
 }
 
-func (s *store) NotifyClients(event models.Event, environmentID string) {
+// Snapshot returns the initial event for a newly subscribed client
+func (s *store) Snapshot(client *models.Client, features []*models.Feature) models.Event {
 	s.mu.RLock()
-	for client := range s.clients {
-		if client.SDKKey == environmentID {
-			client.Messages <- event
+	sub, ok := s.clients[client]
+	s.mu.RUnlock()
+
+	// the client may have been dropped since it subscribed
+	if !ok {
+		sub = &subscription{}
+	}
+	if sub.tags != nil {
+		features = sub.tags.snapshot(features)
+	}
+	if sub.clientSide != nil {
+		return sub.clientSide.snapshot(client, features)
+	}
+
+	featuresData, _ := json.Marshal(features)
+	return models.Event{
+		Type: "all_features",
+		Data: featuresData,
+	}
+}
+
+func (s *store) NotifyClients(ctx context.Context, event models.Event, environmentID string) {
+	_, span := telemetry.Tracer().Start(ctx, "store.notify_clients",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("modulyn.event_type", event.Type),
+			attribute.String("modulyn.environment_id", environmentID),
+		),
+	)
+	defer span.End()
+
+	recipients := 0
+	s.mu.RLock()
+	for client, sub := range s.clients {
+		if client.EnvironmentID != environmentID {
+			continue
+		}
+		clientEvent, ok := event, true
+		if sub.tags != nil {
+			clientEvent, ok = sub.tags.filter(clientEvent)
+		}
+		if ok && sub.clientSide != nil {
+			clientEvent, ok = sub.clientSide.translate(client, clientEvent)
+		}
+		if ok {
+			client.Messages <- clientEvent
+			recipients++
 		}
 	}
 	s.mu.RUnlock()
+
+	span.SetAttributes(attribute.Int("modulyn.recipients", recipients))
+}
+
+// DisconnectKey drops every client connected with the given SDK key and
+// returns how many were dropped
+func (s *store) DisconnectKey(keyID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	disconnected := 0
+	for client := range s.clients {
+		if client.SDKKeyID == keyID {
+			close(client.Done)
+			delete(s.clients, client)
+			disconnected++
+		}
+	}
+	return disconnected
+}
+
+// ConnectedClients returns how many SDKs are subscribed to an environment
+func (s *store) ConnectedClients(environmentID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	connected := 0
+	for client := range s.clients {
+		if client.EnvironmentID == environmentID {
+			connected++
+		}
+	}
+	return connected
 }
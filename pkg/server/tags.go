@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"modulyn/pkg/models"
+	"slices"
+	"sync"
+)
+
+// tagView tracks the feature keys a subscriber limited to some tags has been
+// sent, so a feature whose tags stop matching is withdrawn as if it had been
+// deleted
+type tagView struct {
+	mu   sync.Mutex
+	tags []string
+	// all requires every tag instead of any of them
+	all  bool
+	keys map[string]struct{}
+}
+
+func newTagView(tags []string, tagMatch string) *tagView {
+	return &tagView{
+		tags: tags,
+		all:  tagMatch == models.TagMatchAll,
+		keys: make(map[string]struct{}),
+	}
+}
+
+func (v *tagView) matches(feature *models.Feature) bool {
+	carries := func(tag string) bool {
+		return slices.Contains(feature.Tags, tag)
+	}
+	if v.all {
+		return !slices.ContainsFunc(v.tags, func(tag string) bool { return !carries(tag) })
+	}
+	return slices.ContainsFunc(v.tags, carries)
+}
+
+// snapshot keeps the features carrying the tags
+func (v *tagView) snapshot(features []*models.Feature) []*models.Feature {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	matching := make([]*models.Feature, 0, len(features))
+	for _, feature := range features {
+		if v.matches(feature) {
+			v.keys[feature.Label] = struct{}{}
+			matching = append(matching, feature)
+		}
+	}
+	return matching
+}
+
+// filter passes the feature events the subscriber asked for and turns an
+// update removing a matching tag into a feature_deleted event
+func (v *tagView) filter(event models.Event) (models.Event, bool) {
+	var feature models.Feature
+	if err := json.Unmarshal(event.Data, &feature); err != nil {
+		return models.Event{}, false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, visible := v.keys[feature.Label]
+	if event.Type == "feature_deleted" || !v.matches(&feature) {
+		if !visible {
+			return models.Event{}, false
+		}
+		delete(v.keys, feature.Label)
+		return models.Event{
+			Type: "feature_deleted",
+			Data: event.Data,
+		}, true
+	}
+
+	v.keys[feature.Label] = struct{}{}
+	return event, true
+}
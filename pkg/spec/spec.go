@@ -0,0 +1,266 @@
+// Package spec reads flags-as-code specs and plans the changes that bring
+// a project in line with them
+package spec
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"modulyn/pkg/models"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parse reads a YAML spec, JSON is accepted as well. Unknown fields are
+// rejected so typos do not silently drop settings.
+func Parse(data []byte) (*models.FlagSpec, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var spec models.FlagSpec
+	if err := decoder.Decode(&spec); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("spec is empty")
+		}
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Plan compares the spec with the live environments and features of a
+// project, features as returned by GetFeatures with one row per environment
+func Plan(spec *models.FlagSpec, environments []*models.Environment, features []*models.Feature) *models.SpecPlan {
+	liveEnvironments := make(map[string]*models.Environment, len(environments))
+	for _, environment := range environments {
+		if _, ok := liveEnvironments[environment.Name]; !ok {
+			liveEnvironments[environment.Name] = environment
+		}
+	}
+
+	// live features by key, with their rows by environment name
+	var liveKeys []string
+	liveFeatures := make(map[string]map[string]*models.Feature)
+	for _, feature := range features {
+		rows, ok := liveFeatures[feature.Label]
+		if !ok {
+			rows = make(map[string]*models.Feature)
+			liveFeatures[feature.Label] = rows
+			liveKeys = append(liveKeys, feature.Label)
+		}
+		rows[feature.EnvironmentName] = feature
+	}
+
+	var environmentChanges, featureChanges, stateChanges, deletions []*models.SpecChange
+
+	specEnvironments := make(map[string]bool, len(spec.Environments))
+	for _, environment := range spec.Environments {
+		specEnvironments[environment.Name] = true
+
+		live, ok := liveEnvironments[environment.Name]
+		if !ok {
+			environmentChanges = append(environmentChanges, &models.SpecChange{
+				Action:          models.SpecActionCreate,
+				Resource:        models.SpecResourceEnvironment,
+				Key:             environment.Name,
+				EnvironmentSpec: environment,
+			})
+			continue
+		}
+
+		var fields []string
+		if live.RequiredRole != environment.RequiredRole {
+			fields = append(fields, "requiredRole")
+		}
+		if live.RequiresApproval != environment.RequiresApproval {
+			fields = append(fields, "requiresApproval")
+		}
+		if len(fields) > 0 {
+			environmentChanges = append(environmentChanges, &models.SpecChange{
+				Action:          models.SpecActionUpdate,
+				Resource:        models.SpecResourceEnvironment,
+				Key:             environment.Name,
+				ID:              live.ID,
+				Fields:          fields,
+				EnvironmentSpec: environment,
+			})
+		}
+	}
+
+	specFeatures := make(map[string]bool, len(spec.Features))
+	for _, feature := range spec.Features {
+		specFeatures[feature.Key] = true
+
+		rows, ok := liveFeatures[feature.Key]
+		if !ok {
+			featureChanges = append(featureChanges, &models.SpecChange{
+				Action:      models.SpecActionCreate,
+				Resource:    models.SpecResourceFeature,
+				Key:         feature.Key,
+				FeatureSpec: feature,
+			})
+			continue
+		}
+
+		live := anyRow(rows)
+		if fields := changedFields(feature, live); len(fields) > 0 {
+			featureChanges = append(featureChanges, &models.SpecChange{
+				Action:      models.SpecActionUpdate,
+				Resource:    models.SpecResourceFeature,
+				Key:         feature.Key,
+				ID:          live.ID,
+				Fields:      fields,
+				FeatureSpec: feature,
+			})
+		}
+
+		// environments created by the plan start with the feature disabled
+		for _, environment := range spec.Environments {
+			desired := State(feature, environment.Name)
+			var current models.FeatureStateSpec
+			if row, ok := rows[environment.Name]; ok {
+				current = models.FeatureStateSpec{Enabled: row.Enabled, Targeting: row.JsonValue}
+			}
+
+			var fields []string
+			if current.Enabled != desired.Enabled {
+				fields = append(fields, "enabled")
+			}
+			if !sameTargeting(current.Targeting, desired.Targeting) {
+				fields = append(fields, "targeting")
+			}
+			if len(fields) > 0 {
+				stateChanges = append(stateChanges, &models.SpecChange{
+					Action:      models.SpecActionUpdate,
+					Resource:    models.SpecResourceFeatureState,
+					Key:         feature.Key,
+					ID:          live.ID,
+					Environment: environment.Name,
+					Fields:      fields,
+					State:       desired,
+				})
+			}
+		}
+	}
+
+	for _, key := range liveKeys {
+		live := anyRow(liveFeatures[key])
+		if specFeatures[key] || (!live.Managed && !spec.Prune) {
+			continue
+		}
+		deletions = append(deletions, &models.SpecChange{
+			Action:   models.SpecActionDelete,
+			Resource: models.SpecResourceFeature,
+			Key:      key,
+			ID:       live.ID,
+		})
+	}
+
+	if spec.Prune {
+		for _, environment := range environments {
+			if specEnvironments[environment.Name] {
+				continue
+			}
+			deletions = append(deletions, &models.SpecChange{
+				Action:   models.SpecActionDelete,
+				Resource: models.SpecResourceEnvironment,
+				Key:      environment.Name,
+				ID:       environment.ID,
+			})
+		}
+	}
+
+	changes := make([]*models.SpecChange, 0, len(environmentChanges)+len(featureChanges)+len(stateChanges)+len(deletions))
+	changes = append(changes, environmentChanges...)
+	changes = append(changes, featureChanges...)
+	changes = append(changes, stateChanges...)
+	changes = append(changes, deletions...)
+	return &models.SpecPlan{
+		Changes:     changes,
+		Fingerprint: fingerprint(changes),
+	}
+}
+
+// fingerprint hashes the changes with the desired state they carry, so
+// two plans share it only when applying either writes the same
+func fingerprint(changes []*models.SpecChange) string {
+	type fingerprinted struct {
+		Change      *models.SpecChange
+		Environment *models.EnvironmentSpec
+		Feature     *models.FeatureSpec
+		State       *models.FeatureStateSpec
+	}
+	entries := make([]fingerprinted, 0, len(changes))
+	for _, change := range changes {
+		entries = append(entries, fingerprinted{change, change.EnvironmentSpec, change.FeatureSpec, change.State})
+	}
+
+	data, _ := json.Marshal(entries)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// State is the desired state of a feature in an environment, disabled
+// when the spec leaves the environment out
+func State(feature *models.FeatureSpec, environmentName string) *models.FeatureStateSpec {
+	if state, ok := feature.Environments[environmentName]; ok && state != nil {
+		return state
+	}
+	return &models.FeatureStateSpec{}
+}
+
+func changedFields(feature *models.FeatureSpec, live *models.Feature) []string {
+	var fields []string
+	if live.Name != feature.Name {
+		fields = append(fields, "name")
+	}
+	if live.Description != feature.Description {
+		fields = append(fields, "description")
+	}
+	if live.ClientSideAvailable != feature.ClientSideAvailable {
+		fields = append(fields, "clientSideAvailable")
+	}
+	// tags are stored sorted and without duplicates
+	tags := slices.Compact(slices.Sorted(slices.Values(feature.Tags)))
+	if !slices.Equal(live.Tags, tags) {
+		fields = append(fields, "tags")
+	}
+	if live.Owner != feature.Owner {
+		fields = append(fields, "owner")
+	}
+	if live.Maintainer != feature.Maintainer {
+		fields = append(fields, "maintainer")
+	}
+	if live.Type != cmp.Or(feature.Type, models.FeatureTypeRelease) {
+		fields = append(fields, "type")
+	}
+	if !slices.Equal(live.Links, feature.Links) {
+		fields = append(fields, "links")
+	}
+	// live features without properties have an empty map
+	if !maps.Equal(live.Properties, feature.Properties) {
+		fields = append(fields, "properties")
+	}
+	if live.Managed != feature.Managed {
+		fields = append(fields, "managed")
+	}
+	return fields
+}
+
+func sameTargeting(a, b models.JsonValue) bool {
+	return a.Key == b.Key && a.Enabled == b.Enabled && slices.Equal(a.Values, b.Values)
+}
+
+// anyRow returns one of the environment rows of a feature, they share
+// everything but the state
+func anyRow(rows map[string]*models.Feature) *models.Feature {
+	for _, row := range rows {
+		return row
+	}
+	return nil
+}
@@ -0,0 +1,474 @@
+// Package validation checks write requests before they reach pkg/db and
+// reports every violation at once as a db.ValidationError
+package validation
+
+import (
+	"fmt"
+	"maps"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxNameLength        = 100
+	MaxFeatureKeyLength  = 100
+	MaxDescriptionLength = 1000
+	MaxReasonLength      = 500
+	MaxTargetingKey      = 100
+	MaxTargetingValues   = 1000
+	MaxTargetingValue    = 200
+	MaxTags              = 20
+	MaxTagLength         = 50
+	MaxLinks             = 20
+	MaxURLLength         = 2000
+	MaxProperties        = 20
+	MaxPropertyKey       = 50
+	MaxPropertyValue     = 500
+)
+
+var (
+	// namePattern allows letters, digits, spaces and common punctuation and
+	// requires a letter or digit first
+	namePattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _.\-/()&']*$`)
+	// featureKeyPattern is the format of the key SDKs evaluate a feature by
+	featureKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.\-]*$`)
+	// tagPattern allows lowercase tags such as team:payments or area/checkout
+	tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:/\-]*$`)
+	// targetingKeyPattern matches the user context attributes SDKs send
+	targetingKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+	// propertyKeyPattern keeps custom property names usable in filters
+	propertyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+)
+
+func CreateProject(createProjectRequest *models.CreateProjectRequest) error {
+	v := &db.ValidationError{}
+	name(v, "name", createProjectRequest.Name)
+	return v.Err()
+}
+
+func UpdateProject(updateProjectRequest *models.UpdateProjectRequest) error {
+	v := &db.ValidationError{}
+	name(v, "name", updateProjectRequest.Name)
+	return v.Err()
+}
+
+// CreateEnvironment checks a new environment, the source environment must
+// be one of the project's environments
+func CreateEnvironment(createEnvironmentRequest *models.CreateEnvironmentRequest, environments []*models.Environment) error {
+	v := &db.ValidationError{}
+	name(v, "name", createEnvironmentRequest.Name)
+	if sourceEnvironmentID := createEnvironmentRequest.SourceEnvironmentID; sourceEnvironmentID != "" && !slices.ContainsFunc(environments, func(e *models.Environment) bool {
+		return e.ID == sourceEnvironmentID
+	}) {
+		v.Add("sourceEnvironmentId", fmt.Sprintf("environment %s does not exist in this project", sourceEnvironmentID))
+	}
+	return v.Err()
+}
+
+func UpdateEnvironment(updateEnvironmentRequest *models.UpdateEnvironmentRequest) error {
+	v := &db.ValidationError{}
+	name(v, "name", updateEnvironmentRequest.Name)
+	if requiredRole := updateEnvironmentRequest.RequiredRole; requiredRole != nil && *requiredRole != "" && !models.IsValidRole(*requiredRole) {
+		v.Add("requiredRole", "must be one of viewer, editor or admin")
+	}
+	return v.Err()
+}
+
+func CreateFeature(createFeatureRequest *models.CreateFeatureRequest) error {
+	v := &db.ValidationError{}
+	name(v, "name", createFeatureRequest.Name)
+	if createFeatureRequest.Key != "" {
+		featureKey(v, "key", createFeatureRequest.Key)
+	}
+	text(v, "description", createFeatureRequest.Description, MaxDescriptionLength)
+	tags(v, "tags", createFeatureRequest.Tags)
+	text(v, "owner", createFeatureRequest.Owner, MaxNameLength)
+	text(v, "maintainer", createFeatureRequest.Maintainer, MaxNameLength)
+	featureType(v, "type", createFeatureRequest.Type)
+	properties(v, "properties", createFeatureRequest.Properties)
+	links(v, "links", createFeatureRequest.Links)
+	return v.Err()
+}
+
+func UpdateFeatureMetadata(updateFeatureMetadataRequest *models.UpdateFeatureMetadataRequest) error {
+	v := &db.ValidationError{}
+	if r := updateFeatureMetadataRequest; r.Name == nil && r.Description == nil && r.Tags == nil && r.Owner == nil && r.Maintainer == nil && r.Type == nil && r.Properties == nil && r.Links == nil {
+		v.Add("body", "must change at least one field")
+		return v.Err()
+	}
+
+	if updateFeatureMetadataRequest.Name != nil {
+		name(v, "name", *updateFeatureMetadataRequest.Name)
+	}
+	if updateFeatureMetadataRequest.Description != nil {
+		text(v, "description", *updateFeatureMetadataRequest.Description, MaxDescriptionLength)
+	}
+	if updateFeatureMetadataRequest.Owner != nil {
+		text(v, "owner", *updateFeatureMetadataRequest.Owner, MaxNameLength)
+	}
+	if updateFeatureMetadataRequest.Maintainer != nil {
+		text(v, "maintainer", *updateFeatureMetadataRequest.Maintainer, MaxNameLength)
+	}
+	if updateFeatureMetadataRequest.Type != nil {
+		if *updateFeatureMetadataRequest.Type == "" {
+			v.Add("type", "must not be empty")
+		}
+		featureType(v, "type", *updateFeatureMetadataRequest.Type)
+	}
+	properties(v, "properties", updateFeatureMetadataRequest.Properties)
+	tags(v, "tags", updateFeatureMetadataRequest.Tags)
+	links(v, "links", updateFeatureMetadataRequest.Links)
+	return v.Err()
+}
+
+// RenameTag checks the new name of a tag, which must differ from the old one
+func RenameTag(current string, renameTagRequest *models.RenameTagRequest) error {
+	v := &db.ValidationError{}
+	tag(v, "name", renameTagRequest.Name)
+	if renameTagRequest.Name == current {
+		v.Add("name", "must differ from the current name")
+	}
+	return v.Err()
+}
+
+func Purge(purgeRequest *models.PurgeRequest) error {
+	v := &db.ValidationError{}
+	if retentionDays := purgeRequest.RetentionDays; retentionDays != nil && *retentionDays < 0 {
+		v.Add("retentionDays", "must not be negative")
+	}
+	return v.Err()
+}
+
+// ImportProject checks an export document and the import options. IDs,
+// environment names and feature keys must be unique within the document
+// and states may only refer to its environments.
+func ImportProject(document *models.ProjectExport, options *models.ImportOptions) error {
+	v := &db.ValidationError{}
+	if !slices.Contains(models.ImportStrategies, options.Strategy) {
+		v.Add("strategy", fmt.Sprintf("must be one of %s", strings.Join(models.ImportStrategies, ", ")))
+	}
+	if options.Name != "" {
+		name(v, "name", options.Name)
+	}
+	if document.Version < 1 || document.Version > models.ProjectExportVersion {
+		v.Add("version", fmt.Sprintf("must be between 1 and %d", models.ProjectExportVersion))
+		return v.Err()
+	}
+	name(v, "project.name", document.Project.Name)
+
+	environmentIDs := make(map[string]bool, len(document.Environments))
+	environmentNames := make(map[string]bool, len(document.Environments))
+	for i, environment := range document.Environments {
+		field := fmt.Sprintf("environments[%d]", i)
+		if environment == nil {
+			v.Add(field, "must be an object")
+			continue
+		}
+		if environment.ID == "" {
+			v.Add(field+".id", "is required")
+		} else if environmentIDs[environment.ID] {
+			v.Add(field+".id", "must be unique")
+		}
+		environmentIDs[environment.ID] = true
+
+		name(v, field+".name", environment.Name)
+		if environmentNames[environment.Name] {
+			v.Add(field+".name", "must be unique")
+		}
+		environmentNames[environment.Name] = true
+
+		if environment.RequiredRole != "" && !models.IsValidRole(environment.RequiredRole) {
+			v.Add(field+".requiredRole", "must be one of viewer, editor or admin")
+		}
+	}
+
+	featureIDs := make(map[string]bool, len(document.Features))
+	featureKeys := make(map[string]bool, len(document.Features))
+	for i, feature := range document.Features {
+		field := fmt.Sprintf("features[%d]", i)
+		if feature == nil {
+			v.Add(field, "must be an object")
+			continue
+		}
+		if feature.ID == "" {
+			v.Add(field+".id", "is required")
+		} else if featureIDs[feature.ID] {
+			v.Add(field+".id", "must be unique")
+		}
+		featureIDs[feature.ID] = true
+
+		featureKey(v, field+".key", feature.Key)
+		if featureKeys[feature.Key] {
+			v.Add(field+".key", "must be unique")
+		}
+		featureKeys[feature.Key] = true
+
+		name(v, field+".name", feature.Name)
+		text(v, field+".description", feature.Description, MaxDescriptionLength)
+		text(v, field+".owner", feature.Owner, MaxNameLength)
+		text(v, field+".maintainer", feature.Maintainer, MaxNameLength)
+		featureType(v, field+".type", feature.Type)
+		properties(v, field+".properties", feature.Properties)
+		tags(v, field+".tags", feature.Tags)
+		links(v, field+".links", feature.Links)
+
+		for _, environmentID := range slices.Sorted(maps.Keys(feature.States)) {
+			state := feature.States[environmentID]
+			stateField := fmt.Sprintf("%s.states[%s]", field, environmentID)
+			if !environmentIDs[environmentID] {
+				v.Add(stateField, "must refer to an environment of the document")
+			}
+			jsonValue(v, stateField+".jsonValue", state.JsonValue)
+		}
+	}
+	return v.Err()
+}
+
+// FlagSpec checks a flags-as-code spec. Environment names and feature keys
+// must be unique and features may only set their state in environments the
+// spec declares.
+func FlagSpec(spec *models.FlagSpec) error {
+	v := &db.ValidationError{}
+
+	environmentNames := make(map[string]bool, len(spec.Environments))
+	for i, environment := range spec.Environments {
+		field := fmt.Sprintf("environments[%d]", i)
+		if environment == nil {
+			v.Add(field, "must be an object")
+			continue
+		}
+		name(v, field+".name", environment.Name)
+		if environmentNames[environment.Name] {
+			v.Add(field+".name", "must be unique")
+		}
+		environmentNames[environment.Name] = true
+
+		if environment.RequiredRole != "" && !models.IsValidRole(environment.RequiredRole) {
+			v.Add(field+".requiredRole", "must be one of viewer, editor or admin")
+		}
+	}
+
+	featureKeys := make(map[string]bool, len(spec.Features))
+	for i, feature := range spec.Features {
+		field := fmt.Sprintf("features[%d]", i)
+		if feature == nil {
+			v.Add(field, "must be an object")
+			continue
+		}
+		featureKey(v, field+".key", feature.Key)
+		if featureKeys[feature.Key] {
+			v.Add(field+".key", "must be unique")
+		}
+		featureKeys[feature.Key] = true
+
+		name(v, field+".name", feature.Name)
+		text(v, field+".description", feature.Description, MaxDescriptionLength)
+		text(v, field+".owner", feature.Owner, MaxNameLength)
+		text(v, field+".maintainer", feature.Maintainer, MaxNameLength)
+		featureType(v, field+".type", feature.Type)
+		properties(v, field+".properties", feature.Properties)
+		tags(v, field+".tags", feature.Tags)
+		links(v, field+".links", feature.Links)
+
+		for _, environmentName := range slices.Sorted(maps.Keys(feature.Environments)) {
+			stateField := fmt.Sprintf("%s.environments[%s]", field, environmentName)
+			if !environmentNames[environmentName] {
+				v.Add(stateField, "must be an environment of the spec")
+			}
+			if state := feature.Environments[environmentName]; state != nil {
+				jsonValue(v, stateField+".targeting", state.Targeting)
+			}
+		}
+	}
+	return v.Err()
+}
+
+// CompareEnvironments checks the base and target query parameters of an
+// environment comparison
+func CompareEnvironments(baseEnvironmentID, targetEnvironmentID string, environments []*models.Environment) error {
+	v := &db.ValidationError{}
+	environment(v, "base", baseEnvironmentID, environments)
+	environment(v, "target", targetEnvironmentID, environments)
+	return v.Err()
+}
+
+// Promotion checks that both environments belong to the project and differ
+func Promotion(promotionRequest *models.PromotionRequest, environments []*models.Environment) error {
+	v := &db.ValidationError{}
+	environment(v, "sourceEnvironmentId", promotionRequest.SourceEnvironmentID, environments)
+	environment(v, "targetEnvironmentId", promotionRequest.TargetEnvironmentID, environments)
+	if promotionRequest.SourceEnvironmentID != "" && promotionRequest.SourceEnvironmentID == promotionRequest.TargetEnvironmentID {
+		v.Add("targetEnvironmentId", "must differ from sourceEnvironmentId")
+	}
+
+	seen := make(map[string]bool, len(promotionRequest.FeatureIDs))
+	for i, featureID := range promotionRequest.FeatureIDs {
+		field := fmt.Sprintf("featureIds[%d]", i)
+		switch {
+		case featureID == "":
+			v.Add(field, "must not be empty")
+		case seen[featureID]:
+			v.Add(field, "must not be listed more than once")
+		}
+		seen[featureID] = true
+	}
+	text(v, "reason", promotionRequest.Reason, MaxReasonLength)
+	return v.Err()
+}
+
+// UpdateFeatures checks a per environment update of a feature, every
+// environment must belong to the project and appear at most once
+func UpdateFeatures(updateFeaturesRequest []*models.UpdateFeatureRequest, environments []*models.Environment) error {
+	v := &db.ValidationError{}
+	if len(updateFeaturesRequest) == 0 {
+		v.Add("body", "must contain at least one environment")
+		return v.Err()
+	}
+
+	known := make(map[string]bool, len(environments))
+	for _, environment := range environments {
+		known[environment.ID] = true
+	}
+
+	seen := make(map[string]bool, len(updateFeaturesRequest))
+	for i, updateFeatureRequest := range updateFeaturesRequest {
+		field := fmt.Sprintf("[%d]", i)
+		if updateFeatureRequest == nil {
+			v.Add(field, "must be an object")
+			continue
+		}
+
+		switch environmentID := updateFeatureRequest.EnvironmentID; {
+		case environmentID == "":
+			v.Add(field+".environmentId", "is required")
+		case !known[environmentID]:
+			v.Add(field+".environmentId", fmt.Sprintf("environment %s does not exist in this project", environmentID))
+		case seen[environmentID]:
+			v.Add(field+".environmentId", "must not be updated more than once")
+		}
+		seen[updateFeatureRequest.EnvironmentID] = true
+
+		jsonValue(v, field+".jsonValue", updateFeatureRequest.JsonValue)
+		text(v, field+".reason", updateFeatureRequest.Reason, MaxReasonLength)
+	}
+	return v.Err()
+}
+
+func environment(v *db.ValidationError, field, environmentID string, environments []*models.Environment) {
+	if environmentID == "" {
+		v.Add(field, "is required")
+	} else if !slices.ContainsFunc(environments, func(e *models.Environment) bool {
+		return e.ID == environmentID
+	}) {
+		v.Add(field, fmt.Sprintf("environment %s does not exist in this project", environmentID))
+	}
+}
+
+func featureKey(v *db.ValidationError, field, key string) {
+	if len(key) > MaxFeatureKeyLength || !featureKeyPattern.MatchString(key) {
+		v.Add(field, fmt.Sprintf("must be at most %d lowercase letters, digits, '_', '.' or '-' and start with a letter or digit", MaxFeatureKeyLength))
+	}
+}
+
+func jsonValue(v *db.ValidationError, field string, jsonValue models.JsonValue) {
+	if jsonValue.Key == "" {
+		if jsonValue.Enabled {
+			v.Add(field+".key", "is required when targeting is enabled")
+		}
+	} else if len(jsonValue.Key) > MaxTargetingKey || !targetingKeyPattern.MatchString(jsonValue.Key) {
+		v.Add(field+".key", fmt.Sprintf("must be at most %d letters, digits, '_', '.' or '-'", MaxTargetingKey))
+	}
+
+	if len(jsonValue.Values) > MaxTargetingValues {
+		v.Add(field+".values", fmt.Sprintf("must contain at most %d values", MaxTargetingValues))
+		return
+	}
+	for i, value := range jsonValue.Values {
+		if value == "" || utf8.RuneCountInString(value) > MaxTargetingValue || hasControlCharacters(value) {
+			v.Add(fmt.Sprintf("%s.values[%d]", field, i), fmt.Sprintf("must be between 1 and %d printable characters", MaxTargetingValue))
+		}
+	}
+}
+
+func tags(v *db.ValidationError, field string, tags []string) {
+	if len(tags) > MaxTags {
+		v.Add(field, fmt.Sprintf("must contain at most %d tags", MaxTags))
+		return
+	}
+	for i, value := range tags {
+		tag(v, fmt.Sprintf("%s[%d]", field, i), value)
+	}
+}
+
+func tag(v *db.ValidationError, field, value string) {
+	if len(value) > MaxTagLength || !tagPattern.MatchString(value) {
+		v.Add(field, fmt.Sprintf("must be at most %d lowercase letters, digits, '_', '.', ':', '/' or '-' and start with a letter or digit", MaxTagLength))
+	}
+}
+
+// featureType accepts an empty type, which defaults to release
+func featureType(v *db.ValidationError, field, value string) {
+	if value != "" && !slices.Contains(models.FeatureTypes, value) {
+		v.Add(field, "must be one of "+strings.Join(models.FeatureTypes, ", "))
+	}
+}
+
+func properties(v *db.ValidationError, field string, properties map[string]string) {
+	if len(properties) > MaxProperties {
+		v.Add(field, fmt.Sprintf("must contain at most %d properties", MaxProperties))
+		return
+	}
+	for _, key := range slices.Sorted(maps.Keys(properties)) {
+		itemField := fmt.Sprintf("%s[%s]", field, key)
+		if len(key) > MaxPropertyKey || !propertyKeyPattern.MatchString(key) {
+			v.Add(itemField, fmt.Sprintf("must be named with at most %d letters, digits, '_', '.' or '-'", MaxPropertyKey))
+		}
+		text(v, itemField, properties[key], MaxPropertyValue)
+	}
+}
+
+func links(v *db.ValidationError, field string, links []models.Link) {
+	if len(links) > MaxLinks {
+		v.Add(field, fmt.Sprintf("must contain at most %d links", MaxLinks))
+		return
+	}
+	for i, link := range links {
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		u, err := url.Parse(link.URL)
+		if len(link.URL) > MaxURLLength || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.Add(itemField+".url", fmt.Sprintf("must be an absolute http or https URL of at most %d characters", MaxURLLength))
+		}
+		text(v, itemField+".title", link.Title, MaxNameLength)
+	}
+}
+
+func name(v *db.ValidationError, field, value string) {
+	switch {
+	case strings.TrimSpace(value) == "":
+		v.Add(field, "is required")
+	case utf8.RuneCountInString(value) > MaxNameLength:
+		v.Add(field, fmt.Sprintf("must be at most %d characters", MaxNameLength))
+	case value != strings.TrimSpace(value):
+		v.Add(field, "must not start or end with whitespace")
+	case !namePattern.MatchString(value):
+		v.Add(field, "must start with a letter or digit and only contain letters, digits, spaces and _ . - / ( ) & '")
+	}
+}
+
+func text(v *db.ValidationError, field, value string, maxLength int) {
+	if utf8.RuneCountInString(value) > maxLength {
+		v.Add(field, fmt.Sprintf("must be at most %d characters", maxLength))
+	} else if hasControlCharacters(strings.NewReplacer("\n", "", "\r", "", "\t", "").Replace(value)) {
+		v.Add(field, "must not contain control characters")
+	}
+}
+
+func hasControlCharacters(value string) bool {
+	return strings.ContainsFunc(value, unicode.IsControl)
+}
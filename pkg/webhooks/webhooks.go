@@ -0,0 +1,319 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"modulyn/pkg/db"
+	"modulyn/pkg/models"
+	"modulyn/pkg/telemetry"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	SignatureHeader = "X-Modulyn-Signature"
+	TimestampHeader = "X-Modulyn-Timestamp"
+	EventHeader     = "X-Modulyn-Event"
+	DeliveryHeader  = "X-Modulyn-Delivery"
+
+	// maxRetryDelay caps the exponential backoff between attempts
+	maxRetryDelay = time.Hour
+	// batchSize is how many due deliveries are attempted per poll
+	batchSize = 50
+)
+
+type Config struct {
+	// MaxAttempts is how often a delivery is attempted before it fails
+	MaxAttempts int
+	// RetryBase is the delay before the first retry, doubled on every
+	// following attempt
+	RetryBase time.Duration
+	// Timeout bounds a single delivery request
+	Timeout time.Duration
+	// PollInterval is how often due retries are picked up
+	PollInterval time.Duration
+	// AllowPrivateNetworks lets webhooks reach loopback, private and
+	// link-local addresses, only enable it when every project admin is
+	// trusted with the server's network
+	AllowPrivateNetworks bool
+}
+
+// ConfigFromEnv reads the delivery settings from the WEBHOOK_* environment
+// variables
+func ConfigFromEnv() Config {
+	return Config{
+		MaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		RetryBase:    time.Duration(envInt("WEBHOOK_RETRY_BASE_SECONDS", 30)) * time.Second,
+		Timeout:      time.Duration(envInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+		PollInterval: time.Duration(envInt("WEBHOOK_POLL_INTERVAL_SECONDS", 5)) * time.Second,
+
+		AllowPrivateNetworks: os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true",
+	}
+}
+
+// Dispatcher persists events for the webhooks subscribed to them and
+// delivers them in the background, retrying failed attempts
+type Dispatcher struct {
+	conn   db.WebhookDB
+	config Config
+	client *http.Client
+	wake   chan struct{}
+}
+
+func New(conn db.WebhookDB, config Config) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !config.AllowPrivateNetworks {
+		// checked on every connection, a host may resolve to another
+		// address than when the webhook was saved and redirects are
+		// followed
+		dialer := &net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+					return fmt.Errorf("webhook address %s is not public", host)
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	return &Dispatcher{
+		conn:   conn,
+		config: config,
+		client: &http.Client{Timeout: config.Timeout, Transport: transport},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// ValidateURL checks that a webhook URL is an absolute http or https URL
+// and, unless private networks are allowed, that its host only resolves
+// to public addresses
+func (d *Dispatcher) ValidateURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if d.config.AllowPrivateNetworks {
+		return nil
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil || len(addresses) == 0 {
+		return fmt.Errorf("url host %q cannot be resolved", parsed.Hostname())
+	}
+	for _, address := range addresses {
+		if isPrivateIP(address.IP) {
+			return errors.New("url must not resolve to a loopback, private or link-local address")
+		}
+	}
+	return nil
+}
+
+// isPrivateIP reports whether ip is not reachable on the public internet
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// Sign returns the signature sent in the X-Modulyn-Signature header, the
+// hex encoded HMAC-SHA256 of the timestamp and the body joined by a dot
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish queues an event for the project's webhooks subscribed to its
+// type. Events are the same ones broadcast to connected SDKs.
+func (d *Dispatcher) Publish(ctx context.Context, projectID, environmentID string, event models.Event) {
+	payload, _ := json.Marshal(newPayload(event.Type, projectID, environmentID, json.RawMessage(event.Data)))
+
+	n, err := d.conn.EnqueueWebhookDeliveries(ctx, projectID, event.Type, payload)
+	if err != nil {
+		log.Println("Error queueing webhook deliveries:", err)
+		return
+	}
+	if n > 0 {
+		d.notify()
+	}
+}
+
+// Test sends a test event to a webhook right away and returns the outcome
+// of the attempt. Failed test deliveries are not retried.
+func (d *Dispatcher) Test(ctx context.Context, webhook *models.Webhook) (*models.WebhookDelivery, error) {
+	payload, _ := json.Marshal(newPayload(models.WebhookEventTest, webhook.ProjectID, "", map[string]string{
+		"webhookId": webhook.ID,
+	}))
+
+	delivery, err := d.conn.CreateWebhookDelivery(ctx, webhook.ID, models.WebhookEventTest, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	d.attempt(ctx, delivery)
+	if delivery.Status == models.WebhookDeliveryPending {
+		delivery.Status = models.WebhookDeliveryFailed
+	}
+	if err := d.conn.RecordWebhookAttempt(ctx, delivery, nil); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Run delivers due deliveries until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	deliveries, err := d.conn.GetDueWebhookDeliveries(ctx, batchSize)
+	if err != nil {
+		log.Println("Error getting due webhook deliveries:", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		d.attempt(ctx, delivery)
+
+		var nextAttemptAt *time.Time
+		if delivery.Status == models.WebhookDeliveryPending {
+			if delivery.Attempts >= d.config.MaxAttempts {
+				delivery.Status = models.WebhookDeliveryFailed
+			} else {
+				next := time.Now().UTC().Add(d.retryDelay(delivery.Attempts))
+				nextAttemptAt = &next
+			}
+		}
+
+		if err := d.conn.RecordWebhookAttempt(ctx, delivery, nextAttemptAt); err != nil {
+			log.Println("Error recording webhook attempt:", err)
+		}
+	}
+}
+
+// attempt posts the delivery once and updates its status, attempts and
+// response in place. A delivery that should be retried stays pending.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	ctx, span := telemetry.Tracer().Start(ctx, "webhook.deliver")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("modulyn.webhook_id", delivery.WebhookID),
+		attribute.String("modulyn.webhook_delivery_id", delivery.ID),
+		attribute.String("modulyn.event_type", delivery.EventType),
+	)
+
+	delivery.Attempts++
+	delivery.ResponseStatus = 0
+	delivery.Error = ""
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = err.Error()
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "modulyn-webhooks")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.Error = err.Error()
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+
+	delivery.ResponseStatus = res.StatusCode
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		delivery.Status = models.WebhookDeliverySucceeded
+		return
+	}
+
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Error = res.Status
+	span.SetStatus(codes.Error, res.Status)
+}
+
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.config.RetryBase
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func newPayload(eventType, projectID, environmentID string, data any) models.WebhookPayload {
+	eventID, _ := uuid.NewRandom()
+	return models.WebhookPayload{
+		ID:            eventID.String(),
+		Type:          eventType,
+		ProjectID:     projectID,
+		EnvironmentID: environmentID,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		Data:          data,
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return parsed
+}